@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/smorenodp/clusterprofile/config"
 	"github.com/smorenodp/clusterprofile/providers"
@@ -35,50 +37,51 @@ func NewClusterProfile(args CommandArgs) (*ClusterProfile, error) {
 	return cluster, err
 }
 
-// TODO: Refactor this function
 func (cp *ClusterProfile) GenerateVaultClient() (err error) {
-	var profile config.ClusterConfig
-	var creds []string
-	var client *providers.VaultClient
-	if profile, creds, err = cp.GetProfile(cp.profile.Name); err != nil {
+	var chain []string
+	if chain, err = cp.PivotChain(cp.profile.Name); err != nil {
 		return
 	}
-	client, err = providers.NewVaultClient(profile.Vault)
-
-	cp.vaultClient = client
-
-	if loaded := client.LoadProfileCreds(creds); loaded {
+	if cp.vaultClient, err = cp.loadVaultClient(chain); err != nil {
+		return
+	}
+	if cp.vaultClient.CredsLoaded() {
 		cp.profile.Export = append(cp.profile.Export, cp.vaultClient.ExportCreds()...)
 		cp.profile.Creds = append(cp.profile.Creds, cp.vaultClient.ProfileCreds()...)
-		return
 	}
-	if profile.Vault.PivotProfile != "" {
-		var pivotConfig config.ClusterConfig
-		var pivotCreds []string
-		if pivotConfig, pivotCreds, err = cp.GetProfile(profile.Vault.PivotProfile); err != nil {
-			return
-		}
+	return
+}
 
-		_, err := client.WithPivotRole(pivotConfig.Vault, pivotCreds)
-		cp.profilesCreds[profile.Vault.PivotProfile] = client.Pivot.ProfileCreds()
-		if err != nil {
-			return err
-		}
-		_, err = client.GenerateCreds()
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err = client.GenerateCreds()
+// loadVaultClient logs in the first profile of the chain, walking down the
+// rest of the chain only when its credentials are not cached.
+func (cp *ClusterProfile) loadVaultClient(chain []string) (*providers.VaultClient, error) {
+	profile, creds, err := cp.GetProfile(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	client, err := providers.NewVaultClient(profile.Vault)
+	if err != nil {
+		return nil, err
+	}
+	if loaded := client.LoadProfileCreds(creds); loaded {
+		return client, nil
+	}
+	if len(chain) > 1 {
+		pivot, err := cp.loadVaultClient(chain[1:])
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("error loading pivot profile %s - %s", chain[1], err)
 		}
+		cp.profilesCreds[chain[1]] = replaceCreds(cp.profilesCreds[chain[1]], pivot.ProfileCreds())
+		client.WithPivot(pivot)
 	}
-	if cp.vaultClient.CredsLoaded() {
-		cp.profile.Export = append(cp.profile.Export, cp.vaultClient.ExportCreds()...)
-		cp.profile.Creds = append(cp.profile.Creds, cp.vaultClient.ProfileCreds()...)
+	if _, err = client.GenerateCreds(); err != nil {
+		return nil, err
 	}
-	return
+	return client, nil
+}
+
+func (cp *ClusterProfile) PivotChain(name string) ([]string, error) {
+	return config.PivotChain(cp.profilesConfig, name)
 }
 
 func (cp *ClusterProfile) GetProfile(name string) (pConfig config.ClusterConfig, pCreds []string, err error) {
@@ -87,6 +90,10 @@ func (cp *ClusterProfile) GetProfile(name string) (pConfig config.ClusterConfig,
 		err = fmt.Errorf("Error loading profile config %s", name)
 		return
 	}
+	if pConfig.Duplicate != nil {
+		err = pConfig.Duplicate
+		return
+	}
 	pCreds = cp.profilesCreds[name]
 
 	return
@@ -103,26 +110,256 @@ func (cp *ClusterProfile) RemoveProfile(name string) (err error) {
 	return
 }
 
-func (cp *ClusterProfile) ExecuteProviders() {
+// ReleaseProviders releases the cached credentials of the providers of the
+// profile that can be removed, using the cached vault token of the profile,
+// and deletes their files, expired or not.
+func (cp *ClusterProfile) ReleaseProviders(name string) error {
+	pConfig, pCreds, err := cp.GetProfile(name)
+	if err != nil {
+		return err
+	}
+	client, err := providers.NewVaultClient(pConfig.Vault)
+	if err != nil {
+		return err
+	}
+	if !client.LoadProfileCreds(pCreds) && !client.StaticToken() {
+		client = nil
+	}
+	ordered, err := orderProviders(pConfig.Providers)
+	if err != nil {
+		return err
+	}
+	outputs := config.Outputs{}
+	for _, p := range ordered {
+		if p, err = p.Resolve(outputs); err != nil {
+			return err
+		}
+		provider, err := providers.NewProvider(client, p)
+		if err != nil || provider == nil {
+			continue
+		}
+		if consumer, ok := provider.(providers.OutputsConsumer); ok {
+			consumer.SetOutputs(outputs)
+		}
+		provider.LoadProfileCreds(pCreds)
+		remover, ok := provider.(providers.Remover)
+		if provider.CredsLoaded() {
+			outputs[p.ID()] = credsOutputs(provider.ProfileCreds())
+			if ok {
+				if err = remover.RevokeCreds(); err != nil {
+					errorLog.Printf("Error releasing credentials of provider %s - %s\n", p.ID(), err)
+				}
+			}
+		}
+		// The files are removed even when the credentials expired.
+		if ok {
+			if err = remover.RemoveFiles(); err != nil {
+				errorLog.Printf("Error removing the files of provider %s - %s\n", p.ID(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (cp *ClusterProfile) ExecuteProviders() error {
 	pConfig, pCreds, _ := cp.GetProfile(cp.profile.Name)
-	for _, p := range pConfig.Providers {
-		provider := providers.NewProvider(cp.vaultClient, p)
+	ordered, err := orderProviders(pConfig.Providers)
+	if err != nil {
+		return err
+	}
+	outputs := config.Outputs{}
+	for _, p := range ordered {
+		if p, err = p.Resolve(outputs); err != nil {
+			return err
+		}
+		provider, err := providers.NewProvider(cp.vaultClient, p)
+		if err != nil {
+			return fmt.Errorf("error creating provider %s - %s", p.ID(), err)
+		}
 		if provider != nil {
+			if consumer, ok := provider.(providers.OutputsConsumer); ok {
+				consumer.SetOutputs(outputs)
+			}
 			provider.LoadProfileCreds(pCreds)
 			if !provider.CredsLoaded() {
-				provider.GenerateCreds()
+				if _, err = provider.GenerateCreds(); err != nil {
+					errorLog.Printf("Error generating credentials for provider %s - %s\n", p.ID(), err)
+				}
 			}
 			if provider.CredsLoaded() {
 				cp.profile.Export = append(cp.profile.Export, provider.ExportCreds()...)
 				cp.profile.Creds = append(cp.profile.Creds, provider.ProfileCreds()...)
+				outputs[p.ID()] = credsOutputs(provider.ProfileCreds())
 			}
 		} else {
-			errorLog.Println("Provider of type %s not implemented\n", p.Type)
+			errorLog.Printf("Provider of type %s not implemented\n", p.Type)
 		}
 	}
+	return nil
 }
 
-func (cp *ClusterProfile) Run() {
-	cp.ExecuteProviders()
+// LoadProvider loads the cached credentials of a single provider of the
+// profile and the providers it references. When they are missing or expired
+// only that provider generates them, with the cached vault token, so it never
+// logs in vault nor runs the rest of the providers. It reports if the
+// credentials were generated and must be saved.
+func (cp *ClusterProfile) LoadProvider(id string) (providers.Provider, bool, error) {
+	pConfig, pCreds, err := cp.GetProfile(cp.profile.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	ordered, err := orderProviders(pConfig.Providers)
+	if err != nil {
+		return nil, false, err
+	}
+	needed := map[string]bool{id: true}
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if needed[ordered[i].ID()] {
+			for _, ref := range append(ordered[i].References(), providers.Dependencies(ordered[i])...) {
+				needed[ref] = true
+			}
+		}
+	}
+	client, err := providers.NewVaultClient(pConfig.Vault)
+	if err != nil {
+		return nil, false, err
+	}
+	if !client.LoadProfileCreds(pCreds) && !client.StaticToken() {
+		client = nil
+	}
+	outputs := config.Outputs{}
+	for _, p := range ordered {
+		if !needed[p.ID()] {
+			continue
+		}
+		if p, err = p.Resolve(outputs); err != nil {
+			return nil, false, err
+		}
+		provider, err := providers.NewProvider(client, p)
+		if err != nil {
+			return nil, false, fmt.Errorf("error creating provider %s - %s", p.ID(), err)
+		}
+		if provider == nil {
+			return nil, false, fmt.Errorf("provider %s of type %s not implemented", p.ID(), p.Type)
+		}
+		if consumer, ok := provider.(providers.OutputsConsumer); ok {
+			consumer.SetOutputs(outputs)
+		}
+		provider.LoadProfileCreds(pCreds)
+		if p.ID() != id {
+			outputs[p.ID()] = credsOutputs(provider.ProfileCreds())
+			continue
+		}
+		if provider.CredsLoaded() {
+			return provider, false, nil
+		}
+		if client == nil {
+			return nil, false, fmt.Errorf("no valid vault token for profile %s, run clusterprofile load -p %s", cp.profile.Name, cp.profile.Name)
+		}
+		if _, err = provider.GenerateCreds(); err != nil {
+			return nil, false, fmt.Errorf("error generating credentials for provider %s - %s", id, err)
+		}
+		cp.profilesCreds[cp.profile.Name] = replaceCreds(pCreds, provider.ProfileCreds())
+		return provider, true, nil
+	}
+	return nil, false, fmt.Errorf("provider %s not found in profile %s", id, cp.profile.Name)
+}
+
+// orderProviders sorts the providers so every provider runs after the ones it
+// references or uses implicitly, keeping the configured order otherwise.
+func orderProviders(configs []config.ProviderConfig) ([]config.ProviderConfig, error) {
+	names := map[string]bool{}
+	for _, p := range configs {
+		if names[p.ID()] {
+			return nil, fmt.Errorf("duplicate provider %s, give it a different name", p.ID())
+		}
+		names[p.ID()] = true
+	}
+	refs := make([][]string, len(configs))
+	for i, p := range configs {
+		refs[i] = p.References()
+		for _, ref := range refs[i] {
+			if !names[ref] {
+				return nil, fmt.Errorf("provider %s references unknown provider %s", p.ID(), ref)
+			}
+		}
+		// The implicit dependencies are optional, as the values can also be
+		// configured in the provider.
+		for _, dependency := range providers.Dependencies(p) {
+			if names[dependency] && dependency != p.ID() {
+				refs[i] = append(refs[i], dependency)
+			}
+		}
+	}
+	ordered := []config.ProviderConfig{}
+	done := make([]bool, len(configs))
+	generated := map[string]bool{}
+	for len(ordered) < len(configs) {
+		added := false
+		for i, p := range configs {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, ref := range refs[i] {
+				ready = ready && generated[ref]
+			}
+			if ready {
+				ordered = append(ordered, p)
+				done[i], generated[p.ID()] = true, true
+				added = true
+			}
+		}
+		if !added {
+			pending := []string{}
+			for i, p := range configs {
+				if !done[i] {
+					pending = append(pending, p.ID())
+				}
+			}
+			return nil, fmt.Errorf("providers reference each other in a cycle: %s", strings.Join(pending, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// credsOutputs parses the credential lines of a provider into its variables.
+func credsOutputs(creds []string) map[string]string {
+	outputs := map[string]string{}
+	for _, line := range creds {
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			value = parts[1]
+		}
+		outputs[parts[0]] = value
+	}
+	return outputs
+}
+
+func (cp *ClusterProfile) Run() error {
+	if err := cp.ExecuteProviders(); err != nil {
+		return err
+	}
 	cp.profilesCreds[cp.profile.Name] = cp.profile.Creds //TODO: Change this, i don't like it
+	return nil
+}
+
+// replaceCreds overwrites the variables of creds present in updated, keeping
+// the rest of the lines untouched.
+func replaceCreds(creds []string, updated []string) []string {
+	result := []string{}
+	names := map[string]bool{}
+	for _, line := range updated {
+		names[strings.SplitN(line, "=", 2)[0]] = true
+	}
+	for _, line := range creds {
+		if !names[strings.SplitN(line, "=", 2)[0]] {
+			result = append(result, line)
+		}
+	}
+	return append(result, updated...)
 }
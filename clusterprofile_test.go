@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+// testVault starts a vault stand-in answering the paths of responses, with a
+// value or a status code, and records every request it gets.
+func testVault(t *testing.T, responses map[string]interface{}) (*httptest.Server, *[]string) {
+	t.Helper()
+	requests := &[]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			response = http.StatusNotFound
+		}
+		if status, ok := response.(int); ok {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"errors":["test error"]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// captureStdout returns what run prints.
+func captureStdout(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	err = run()
+	w.Close()
+	os.Stdout = stdout
+	output, _ := io.ReadAll(r)
+	return string(output), err
+}
+
+// testArgs writes the profiles to a temporary folder and returns the
+// arguments to use them with an empty credentials file.
+func testArgs(t *testing.T, profile string, profiles string) CommandArgs {
+	t.Helper()
+	folder := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(folder, "profiles"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "profiles", "profiles.yaml"), []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return CommandArgs{
+		ProfilesConfig:  filepath.Join(folder, "profiles"),
+		CredentialsFile: filepath.Join(folder, "credentials"),
+		ExecutableFile:  filepath.Join(folder, "export.sh"),
+		Profile:         profile,
+	}
+}
+
+func TestLoadProviderOnlyGeneratesTheProvider(t *testing.T) {
+	server, requests := testVault(t, map[string]interface{}{
+		"/v1/aws/creds/deploy": map[string]interface{}{
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"access_key": "AKIATEST", "secret_key": "secret"},
+		},
+	})
+	args := testArgs(t, "dev", fmt.Sprintf(`
+- name: dev
+  vault:
+    addr: %s
+    method: token
+    config:
+      token: static
+  providers:
+  - type: secret
+    config:
+      path: secret/app
+      secret_map:
+        password: PASSWORD
+  - type: aws
+    name: deploy
+    method: creds
+    config:
+      role: deploy
+`, server.URL))
+
+	cp, err := NewClusterProfile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, generated, err := cp.LoadProvider("deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !generated || !provider.CredsLoaded() {
+		t.Fatalf("expected the credentials to be generated")
+	}
+	if strings.Join(*requests, ",") != "GET /v1/aws/creds/deploy" {
+		t.Fatalf("expected only the aws path to be read, got %v", *requests)
+	}
+	if err = config.SaveCreds(args.CredentialsFile, cp.profilesCreds); err != nil {
+		t.Fatal(err)
+	}
+
+	*requests = nil
+	if cp, err = NewClusterProfile(args); err != nil {
+		t.Fatal(err)
+	}
+	if provider, generated, err = cp.LoadProvider("deploy"); err != nil || generated || !provider.CredsLoaded() {
+		t.Fatalf("expected the cached credentials, generated %t - %v", generated, err)
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("expected no request with the cached credentials, got %v", *requests)
+	}
+}
+
+func TestNomadVarRunsAfterNomad(t *testing.T) {
+	nomad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/acl/login":
+			json.NewEncoder(w).Encode(map[string]string{"SecretID": "nomad-token"})
+		case r.URL.Path == "/v1/var/nomad/jobs/app" && r.Header.Get("X-Nomad-Token") == "nomad-token":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Items": map[string]string{"password": "secret"}})
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(nomad.Close)
+	args := testArgs(t, "dev", fmt.Sprintf(`
+- name: dev
+  providers:
+  - type: nomad_var
+    config:
+      path: nomad/jobs/app
+      secret_map:
+        password: APP_PASSWORD
+  - type: nomad
+    method: jwt
+    addr: %s
+    config:
+      auth_method: ci
+      jwt: test-jwt
+`, nomad.URL))
+
+	cp, err := loadProfile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(cp.profile.Export, "\n"), `export APP_PASSWORD="secret"`) {
+		t.Fatalf("expected the nomad variable to be exported, got %v", cp.profile.Export)
+	}
+}
+
+func TestRemoveDeletesFilesOfExpiredCreds(t *testing.T) {
+	server, requests := testVault(t, map[string]interface{}{
+		"/v1/kubernetes/creds/dev": map[string]interface{}{
+			"lease_id":       "kubernetes/creds/dev/abc",
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"service_account_token": "sa-token"},
+		},
+	})
+	kubeconfig := filepath.Join(t.TempDir(), "dev.yaml")
+	args := testArgs(t, "dev", fmt.Sprintf(`
+- name: dev
+  vault:
+    addr: %s
+    method: token
+    config:
+      token: static
+  providers:
+  - type: kubernetes
+    addr: https://dev:6443
+    config:
+      role: dev
+      namespace: apps
+      file: %s
+`, server.URL, kubeconfig))
+	if _, err := loadProfile(args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(kubeconfig); err != nil {
+		t.Fatalf("expected the kubeconfig to be written - %s", err)
+	}
+
+	// The cached token expires.
+	content, _ := os.ReadFile(args.CredentialsFile)
+	expired := regexp.MustCompile(`(CLUSTERPROFILE_KUBERNETES_TTL=)"[^"]*"`).ReplaceAllString(string(content), `$1"2020-01-01 00:00:00"`)
+	if err := os.WriteFile(args.CredentialsFile, []byte(expired), 0600); err != nil {
+		t.Fatal(err)
+	}
+	*requests = nil
+	if err := remove(args); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(kubeconfig); !os.IsNotExist(err) {
+		t.Fatalf("expected the kubeconfig of the expired token to be removed - %v", err)
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("expected no revoke of the expired lease, got %v", *requests)
+	}
+}
+
+func TestDuplicateProfileOnlyFailsWhenUsed(t *testing.T) {
+	args := testArgs(t, "other", "- name: app\n- name: other\n")
+	if err := os.WriteFile(filepath.Join(args.ProfilesConfig, "more.yaml"), []byte("- name: app\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := NewClusterProfile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = cp.GetProfile("other"); err != nil {
+		t.Fatalf("expected the other profile to be loaded - %s", err)
+	}
+	if _, _, err = cp.GetProfile("app"); err == nil || !strings.Contains(err.Error(), "duplicate profile app") {
+		t.Fatalf("expected the duplicate profile error, got %v", err)
+	}
+}
+
+func TestPivotChainThreeHops(t *testing.T) {
+	logins := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := strings.TrimPrefix(r.URL.Path, "/v1/auth/token/create/")
+		logins = append(logins, fmt.Sprintf("%s with %s", role, r.Header.Get("X-Vault-Token")))
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": role + "-token", "lease_duration": 3600, "renewable": true}})
+	}))
+	t.Cleanup(server.Close)
+	args := testArgs(t, "app", fmt.Sprintf(`
+- name: root
+  vault:
+    addr: %[1]s
+    method: token
+    config:
+      token: root-token
+- name: mid
+  vault:
+    addr: %[1]s
+    method: token
+    pivoting_profile: root
+    config:
+      role: mid
+- name: app
+  vault:
+    addr: %[1]s
+    method: token
+    pivoting_profile: mid
+    config:
+      role: app
+`, server.URL))
+	if err := load(args); err != nil {
+		t.Fatal(err)
+	}
+	expected := "mid with root-token,app with mid-token"
+	if got := strings.Join(logins, ","); got != expected {
+		t.Fatalf("expected the logins %s, got %s", expected, got)
+	}
+
+	args.ShowChain = true
+	output, err := captureStdout(t, func() error { return show(args) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "# app -> mid -> root\n[app]\n") || !strings.Contains(output, `VAULT_TOKEN="app-token"`) ||
+		!strings.Contains(output, "[mid]\n") || !strings.Contains(output, `VAULT_TOKEN="mid-token"`) {
+		t.Fatalf("expected the credentials of the chain, got\n%s", output)
+	}
+
+	// The cached token of the profile is reused without walking the chain.
+	logins = nil
+	if err = load(args); err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 0 {
+		t.Fatalf("expected the cached token to be reused, got the logins %v", logins)
+	}
+}
+
+func TestPivotChainCycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles string
+		expected string
+	}{
+		{"self", "- name: app\n  vault:\n    pivoting_profile: app\n", "pivot cycle detected: app -> app"},
+		{"two profiles", "- name: app\n  vault:\n    pivoting_profile: other\n- name: other\n  vault:\n    pivoting_profile: app\n",
+			"pivot cycle detected: app -> other -> app"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := testArgs(t, "app", test.profiles)
+			if err := load(args); err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected %q, got %v", test.expected, err)
+			}
+			args.ShowChain = true
+			if _, err := captureStdout(t, func() error { return show(args) }); err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected %q showing the chain, got %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -2,69 +2,117 @@ package config
 
 import (
 	"fmt"
-	"os"
-	"regexp"
+	"reflect"
 
 	"gopkg.in/yaml.v3"
 )
 
-const (
-	yamlRegex = ".*\\.yaml"
-)
+// ProviderConfig keeps the config of the provider as a raw node, each provider
+// decodes it into its own type with Decode.
+type ProviderConfig struct {
+	Name    string    `yaml:"name"`
+	Type    string    `yaml:"type"`
+	Backend string    `yaml:"backend"`
+	Method  string    `yaml:"method"`
+	Config  yaml.Node `yaml:"config"`
+	Addr    string    `yaml:"addr"`
+	Remove  bool      `yaml:"remove"`
+	Profile string    `yaml:"-"`
+	// Index is the position of the provider in the profile, starting at 1,
+	// set only for the unnamed providers sharing their type with others.
+	Index int `yaml:"-"`
+}
 
-type InnerProviderConfig struct {
-	Role       string            `yaml:"role"`
-	Token      string            `yaml:"token"`
-	Policies   []string          `yaml:"policies"`
-	SecretPath string            `yaml:"path"`
-	Group      string            `yaml:"group"`
-	SecretMap  map[string]string `yaml:"secret_map"`
-	File       string            `yaml:"file"`
-	Data       string            `yaml:"data"`
-	Password   string            `yaml:"password"`
+// Decode decodes the config of the provider into out, rejecting the keys
+// that don't exist in it.
+func (p ProviderConfig) Decode(out interface{}) error {
+	if p.Config.Kind == 0 {
+		return nil
+	}
+	if err := checkKnownFields(&p.Config, reflect.TypeOf(out), ""); err != nil {
+		return err
+	}
+	return p.Config.Decode(out)
 }
 
-type ProviderConfig struct {
-	Type    string              `yaml:"type"`
-	Backend string              `yaml:"backend"`
-	Method  string              `yaml:"method"`
-	Config  InnerProviderConfig `yaml:"config"`
-	Addr    string              `yaml:"addr"`
+type VaultTLSConfig struct {
+	CACert     string `yaml:"ca_cert"`
+	CAPath     string `yaml:"ca_path"`
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	ServerName string `yaml:"server_name"`
+	Insecure   bool   `yaml:"insecure"`
+}
+
+type VaultAuthConfig struct {
+	Role       string   `yaml:"role"`
+	Token      string   `yaml:"token"`
+	Policies   []string `yaml:"policies"`
+	SecretPath string   `yaml:"path"`
+	Mount      string   `yaml:"mount"`
+	RoleID     string   `yaml:"role_id"`
 }
 
 type VaultConfig struct {
-	Addr         string              `yaml:"addr"`
-	Method       string              `yaml:"method"`
-	Config       InnerProviderConfig `yaml:"config"`
-	PivotProfile string              `yaml:"pivoting_profile"`
+	Addr         string          `yaml:"addr"`
+	Namespace    string          `yaml:"namespace"`
+	TLS          VaultTLSConfig  `yaml:"tls"`
+	Method       string          `yaml:"method"`
+	Config       VaultAuthConfig `yaml:"config"`
+	PivotProfile string          `yaml:"pivoting_profile"`
 }
 
 type ClusterConfig struct {
-	Name      string           `yaml:"name"`
-	Vault     VaultConfig      `yaml:"vault"`
-	Providers []ProviderConfig `yaml:"providers"`
+	Name      string            `yaml:"name"`
+	Extends   string            `yaml:"extends"`
+	Vars      map[string]string `yaml:"vars"`
+	Vault     VaultConfig       `yaml:"vault"`
+	Providers []ProviderConfig  `yaml:"providers"`
+	// Duplicate is set when the profile is defined in more than one file, so
+	// it's only reported when the profile is used.
+	Duplicate error `yaml:"-"`
 }
 
 func ReadConfig(folder string) (config map[string]ClusterConfig, err error) {
-	fileRegex := regexp.MustCompile(yamlRegex)
-	var content []byte
-	var profileConfig []ClusterConfig
+	var l *loader
+	var node *yaml.Node
 	config = make(map[string]ClusterConfig)
-	profiles, _ := os.ReadDir(folder)
-	for _, f := range profiles {
-		if fileRegex.MatchString(f.Name()) {
-			content, err = os.ReadFile(fmt.Sprintf("%s/%s", folder, f.Name()))
-			if err != nil {
-				return
-			}
-			err = yaml.Unmarshal(content, &profileConfig)
-			if err != nil {
-				return
-			}
-			for _, p := range profileConfig {
-				config[p.Name] = p
-			}
+	if l, err = newLoader(folder); err != nil {
+		return
+	}
+	for name, p := range l.profiles {
+		if node, err = l.render(name); err != nil {
+			return nil, asConfigError(p.file, err)
 		}
+		var profile ClusterConfig
+		if err = node.Decode(&profile); err != nil {
+			return nil, fmt.Errorf("%s: %s", p.file, err)
+		}
+		for i := range profile.Providers {
+			profile.Providers[i].Profile = name
+		}
+		indexProviders(profile.Providers)
+		if duplicates := l.profileDuplicates(name); len(duplicates) > 0 {
+			profile.Duplicate = duplicates[0]
+		}
+		config[name] = profile
 	}
 	return
 }
+
+// RenderConfig returns the profile with all the profiles it extends merged
+// and its templates executed.
+func RenderConfig(folder string, name string) ([]byte, error) {
+	l, err := newLoader(folder)
+	if err != nil {
+		return nil, err
+	}
+	if duplicates := l.profileDuplicates(name); len(duplicates) > 0 {
+		return nil, duplicates[0]
+	}
+	node, err := l.render(name)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(node)
+}
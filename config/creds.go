@@ -8,7 +8,7 @@ import (
 )
 
 const (
-	nameRegex = "\\[(?P<name>.*)\\]"
+	nameRegex = "^\\[(?P<name>.*)\\]$"
 )
 
 type CredConfig map[string][]string
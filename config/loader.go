@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	yamlRegex = ".*\\.yaml"
+)
+
+type profileNode struct {
+	file string
+	node *yaml.Node
+}
+
+// loader keeps the raw profiles of a folder and the file every node comes
+// from, so merged and templated nodes can still be located.
+type loader struct {
+	profiles   map[string]profileNode
+	duplicates map[string][]profileNode
+	origins    map[*yaml.Node]string
+}
+
+func newLoader(folder string) (l *loader, err error) {
+	fileRegex := regexp.MustCompile(yamlRegex)
+	var content []byte
+	l = &loader{profiles: make(map[string]profileNode), origins: make(map[*yaml.Node]string)}
+	files, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if fileRegex.MatchString(f.Name()) {
+			file := fmt.Sprintf("%s/%s", folder, f.Name())
+			content, err = os.ReadFile(file)
+			if err != nil {
+				return
+			}
+			if err = l.loadFile(file, content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// loadFile reads the profiles of a file, either a list of profiles or a
+// mapping with the profiles and the vars shared by all of them.
+func (l *loader) loadFile(file string, content []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return ConfigError{File: file, Msg: err.Error()}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	l.track(&doc, file)
+	root := doc.Content[0]
+	var vars *yaml.Node
+	if root.Kind == yaml.MappingNode {
+		vars = mappingValue(root, "vars")
+		root = mappingValue(root, "profiles")
+		if root == nil {
+			return nil
+		}
+	}
+	if root.Kind != yaml.SequenceNode {
+		return ConfigError{File: file, Line: root.Line, Column: root.Column, Msg: "expected a list of profiles"}
+	}
+	for _, p := range root.Content {
+		name := mappingValue(p, "name")
+		if name == nil {
+			return ConfigError{File: file, Line: p.Line, Column: p.Column, Msg: "profile without name"}
+		}
+		if profileVars := mappingValue(p, "vars"); vars != nil && profileVars != nil {
+			setMappingValue(p, "vars", l.mergeNodes(vars, profileVars, false))
+		} else if vars != nil {
+			setMappingValue(p, "vars", l.cloneNode(vars))
+		}
+		if existing, ok := l.profiles[name.Value]; ok {
+			if l.duplicates == nil {
+				l.duplicates = make(map[string][]profileNode)
+			}
+			l.duplicates[name.Value] = append(l.duplicates[name.Value], existing)
+		}
+		l.profiles[name.Value] = profileNode{file: file, node: p}
+	}
+	return nil
+}
+
+func (l *loader) track(node *yaml.Node, file string) {
+	l.origins[node] = file
+	for _, child := range node.Content {
+		l.track(child, file)
+	}
+}
+
+// resolve merges the profile with the profiles it extends, the chain holds
+// the profiles already visited to detect cycles.
+func (l *loader) resolve(name string, chain []string) (*yaml.Node, error) {
+	chain = append(chain, name)
+	for _, visited := range chain[:len(chain)-1] {
+		if visited == name {
+			return nil, l.extendsError(chain[len(chain)-2], fmt.Sprintf("extends cycle detected: %s", strings.Join(chain, " -> ")))
+		}
+	}
+	profile, ok := l.profiles[name]
+	if !ok {
+		if len(chain) > 1 {
+			return nil, l.extendsError(chain[len(chain)-2], fmt.Sprintf("profile %s extends unknown profile %s", chain[len(chain)-2], name))
+		}
+		return nil, fmt.Errorf("unknown profile %s", name)
+	}
+	parent := mappingValue(profile.node, "extends")
+	if parent == nil || parent.Value == "" {
+		return l.mergeNodes(nil, profile.node, true), nil
+	}
+	base, err := l.resolve(parent.Value, chain)
+	if err != nil {
+		return nil, err
+	}
+	return l.mergeNodes(base, profile.node, true), nil
+}
+
+func (l *loader) extendsError(name string, msg string) error {
+	profile := l.profiles[name]
+	return l.locate(profile.node, "extends", msg)
+}
+
+// render resolves the profile and executes the templates in the string
+// values of its vault and providers configuration.
+func (l *loader) render(name string) (*yaml.Node, error) {
+	node, err := l.resolve(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if varsNode := mappingValue(node, "vars"); varsNode != nil {
+		if err = varsNode.Decode(&vars); err != nil {
+			return nil, l.locate(varsNode, "", fmt.Sprintf("error decoding vars - %s", err))
+		}
+	}
+	data := map[string]interface{}{"vars": vars, "profile": name}
+	for _, key := range []string{"vault", "providers"} {
+		if value := mappingValue(node, key); value != nil {
+			if err = l.template(value, key, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return node, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+func (l *loader) template(node *yaml.Node, path string, data map[string]interface{}) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "{{") {
+			return nil
+		}
+		tpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(node.Value)
+		if err != nil {
+			return l.locate(node, "", fmt.Sprintf("error parsing template in %s - %s", path, err))
+		}
+		var out bytes.Buffer
+		if err = tpl.Execute(&out, data); err != nil {
+			return l.locate(node, "", fmt.Sprintf("error executing template in %s - %s", path, err))
+		}
+		node.Value = out.String()
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := l.template(node.Content[i+1], fmt.Sprintf("%s.%s", path, node.Content[i].Value), data); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if err := l.template(child, fmt.Sprintf("%s[%d]", path, i), data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
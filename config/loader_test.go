@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeProfiles writes the files to a temporary folder and returns it.
+func writeProfiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	folder := t.TempDir()
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(folder, file), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return folder
+}
+
+func TestTemplates(t *testing.T) {
+	t.Setenv("TEST_USER", "jane")
+	folder := writeProfiles(t, map[string]string{"profiles.yaml": `
+vars:
+  region: eu
+  dc: dc0
+profiles:
+- name: base
+  vars:
+    dc: dc1
+  vault:
+    addr: "https://vault.{{ .vars.region }}.{{ .vars.dc }}:8200"
+  providers:
+  - type: text
+    method: data
+    config:
+      data: |
+        USER={{ env "TEST_USER" }}
+        PROFILE={{ .profile }}
+        TEMPLATE='{{"{{"}} .Values.name }}'
+- name: child
+  extends: base
+  vars:
+    dc: dc2
+- name: file
+`})
+	profiles, err := ReadConfig(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		profile string
+		addr    string
+		data    string
+	}{
+		{"base", "https://vault.eu.dc1:8200", "USER=jane\nPROFILE=base\nTEMPLATE='{{ .Values.name }}'\n"},
+		{"child", "https://vault.eu.dc2:8200", "USER=jane\nPROFILE=child\nTEMPLATE='{{ .Values.name }}'\n"},
+	}
+	for _, test := range tests {
+		profile := profiles[test.profile]
+		if profile.Vault.Addr != test.addr {
+			t.Errorf("expected the addr %s in %s, got %s", test.addr, test.profile, profile.Vault.Addr)
+		}
+		var text struct {
+			Data string `yaml:"data"`
+		}
+		if err = profile.Providers[0].Decode(&text); err != nil {
+			t.Fatal(err)
+		}
+		if text.Data != test.data {
+			t.Errorf("expected the data %q in %s, got %q", test.data, test.profile, text.Data)
+		}
+	}
+	if vars := profiles["file"].Vars; vars["region"] != "eu" || vars["dc"] != "dc0" {
+		t.Errorf("expected the vars of the file, got %v", vars)
+	}
+}
+
+func TestTemplateErrorLocation(t *testing.T) {
+	folder := writeProfiles(t, map[string]string{"profiles.yaml": `- name: app
+  vault:
+    addr: "https://{{ .vars.missing }}:8200"
+`})
+	_, err := ReadConfig(folder)
+	expected := fmt.Sprintf("%s/profiles.yaml:3:11: error executing template in vault.addr", folder)
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected an error starting with %q, got %v", expected, err)
+	}
+}
+
+func TestReadConfigDuplicateProfile(t *testing.T) {
+	folder := writeProfiles(t, map[string]string{
+		"a.yaml": "- name: app\n  vault:\n    addr: https://a:8200\n",
+		"b.yaml": "- name: app\n  vault:\n    addr: https://b:8200\n- name: other\n",
+	})
+	expected := fmt.Sprintf("%s/a.yaml:1:3: duplicate profile app, also defined in %s/b.yaml:1:3", folder, folder)
+
+	// Only the duplicated profile fails, the others can still be used.
+	profiles, err := ReadConfig(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicate := profiles["app"].Duplicate; duplicate == nil || duplicate.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, duplicate)
+	}
+	if duplicate := profiles["other"].Duplicate; duplicate != nil {
+		t.Errorf("expected no error for the other profile, got %v", duplicate)
+	}
+	if _, err = RenderConfig(folder, "other"); err != nil {
+		t.Errorf("expected the other profile to be rendered - %s", err)
+	}
+	if _, err = RenderConfig(folder, "app"); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	errs, err := Validate(folder, Checks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected the duplicate to be a validate error, got %v", errs)
+	}
+}
+
+func TestValidateUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"profile key", "- name: app\n  vaul:\n    addr: https://vault:8200\n",
+			"profiles.yaml:2:3: field vaul not found in type config.ClusterConfig"},
+		{"vault key", "- name: app\n  vault:\n    adr: https://vault:8200\n",
+			"profiles.yaml:3:5: field adr not found in type config.VaultConfig"},
+		{"profiles file key", "vars:\n  env: dev\nprofile:\n- name: app\n",
+			"profiles.yaml:3:1: field profile not found in type config.fileConfig"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			folder := writeProfiles(t, map[string]string{"profiles.yaml": test.content})
+			errs, err := Validate(folder, Checks{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := fmt.Sprintf("%s/%s", folder, test.expected)
+			if len(errs) != 1 || errs[0].Error() != expected {
+				t.Fatalf("expected %q, got %v", expected, errs)
+			}
+		})
+	}
+}
+
+func TestProviderDecodeUnknownKey(t *testing.T) {
+	folder := writeProfiles(t, map[string]string{"profiles.yaml": `- name: app
+  providers:
+  - type: secret
+    config:
+      path: secret/app
+      keys:
+        token: TOKEN
+      recursiv: true
+`})
+	profiles, err := ReadConfig(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Path string            `yaml:"path"`
+		Keys map[string]string `yaml:"keys"`
+	}
+	err = profiles["app"].Providers[0].Decode(&config)
+	expected := "line 8, column 7: unknown key recursiv, expected one of: keys, path"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	nullTag = "!!null"
+)
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingValue(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func (l *loader) cloneNode(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	clone := *node
+	clone.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		clone.Content[i] = l.cloneNode(child)
+	}
+	l.origins[&clone] = l.origins[node]
+	return &clone
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == nullTag
+}
+
+// mergeNodes deep merges override into a copy of base. Mappings are merged key
+// by key, a null value removes the key and any other value replaces the one in
+// base. In the profile (top) the providers are merged by name, type and backend.
+func (l *loader) mergeNodes(base *yaml.Node, override *yaml.Node, top bool) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		result := l.cloneNode(override)
+		if top {
+			deleteMappingValue(result, "extends")
+		}
+		return result
+	}
+	result := l.cloneNode(base)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i].Value, override.Content[i+1]
+		current := mappingValue(result, key)
+		switch {
+		case top && key == "extends":
+		case isNull(value):
+			deleteMappingValue(result, key)
+		case top && key == "providers":
+			setMappingValue(result, key, l.mergeProviders(current, value))
+		case current != nil && current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			setMappingValue(result, key, l.mergeNodes(current, value, false))
+		default:
+			setMappingValue(result, key, l.cloneNode(value))
+		}
+	}
+	return result
+}
+
+func providerKey(node *yaml.Node) string {
+	var providerType, backend string
+	if value := mappingValue(node, "type"); value != nil {
+		providerType = value.Value
+	}
+	if value := mappingValue(node, "backend"); value != nil {
+		backend = value.Value
+	}
+	return fmt.Sprintf("%s/%s", providerType, backend)
+}
+
+// sameProvider reports if the provider of override is the one of base: the
+// one with the same name or, without name, an unnamed one with the same type
+// and backend.
+func sameProvider(base *yaml.Node, override *yaml.Node) bool {
+	baseName, name := mappingValue(base, "name"), mappingValue(override, "name")
+	if name != nil {
+		return baseName != nil && baseName.Value == name.Value
+	}
+	return baseName == nil && providerKey(base) == providerKey(override)
+}
+
+// mergeProviders merges each provider of override with the same one of base,
+// appending the new ones. A provider with remove: true removes the matching
+// one from base. Each provider of base is matched once at most, so unnamed
+// providers of the same type are matched in order.
+func (l *loader) mergeProviders(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.SequenceNode || override.Kind != yaml.SequenceNode {
+		return l.cloneNode(override)
+	}
+	merged := l.cloneNode(base)
+	matched := make([]bool, len(merged.Content))
+	removed := make([]bool, len(merged.Content))
+	appended := []*yaml.Node{}
+	for _, provider := range override.Content {
+		index := -1
+		for i, current := range merged.Content {
+			if !matched[i] && sameProvider(current, provider) {
+				index = i
+				matched[i] = true
+				break
+			}
+		}
+		remove := mappingValue(provider, "remove")
+		switch {
+		case remove != nil && remove.Value == "true":
+			if index != -1 {
+				removed[index] = true
+			}
+		case index != -1:
+			merged.Content[index] = l.mergeNodes(merged.Content[index], provider, false)
+		default:
+			appended = append(appended, l.cloneNode(provider))
+		}
+	}
+	content := []*yaml.Node{}
+	for i, provider := range merged.Content {
+		if !removed[i] {
+			content = append(content, provider)
+		}
+	}
+	merged.Content = append(content, appended...)
+	return merged
+}
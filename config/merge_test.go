@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeProviders(t *testing.T) {
+	folder := t.TempDir()
+	content := `
+- name: base
+  providers:
+  - type: text
+    method: data
+    config:
+      data: A=1
+  - type: text
+    method: data
+    config:
+      data: B=1
+  - type: secret
+    name: db
+    config:
+      path: secret/db
+  - type: secret
+    name: app
+    config:
+      path: secret/app
+- name: child
+  extends: base
+  providers:
+  - type: text
+    config:
+      data: A=2
+  - type: text
+    config:
+      data: B=2
+  - type: text
+    config:
+      data: C=2
+  - type: secret
+    name: app
+    config:
+      path: secret/app2
+  - type: secret
+    name: db
+    remove: true
+`
+	if err := os.WriteFile(filepath.Join(folder, "profiles.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := ReadConfig(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"A=2", "B=2", "secret/app2", "C=2"}
+	providers := profiles["child"].Providers
+	if len(providers) != len(expected) {
+		t.Fatalf("expected %d providers, got %+v", len(expected), providers)
+	}
+	for i, p := range providers {
+		var config map[string]string
+		if err = p.Config.Decode(&config); err != nil {
+			t.Fatal(err)
+		}
+		if value := config["data"] + config["path"]; value != expected[i] {
+			t.Errorf("expected provider %d to be %s, got %s", i, expected[i], value)
+		}
+	}
+}
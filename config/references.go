@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+const (
+	referenceRegex = "\\$\\{providers\\.(?P<provider>[^.}]+)\\.(?P<var>[^}]+)\\}"
+)
+
+var reference = regexp.MustCompile(referenceRegex)
+
+// Outputs holds the variables generated by each provider of a profile,
+// indexed by provider name.
+type Outputs map[string]map[string]string
+
+// ID returns the name of the provider, by default its type. The unnamed
+// providers sharing their type with others get the type and their position,
+// e.g. secret#2, so they must be named to be referenced.
+func (p ProviderConfig) ID() string {
+	switch {
+	case p.Name != "":
+		return p.Name
+	case p.Index > 0:
+		return fmt.Sprintf("%s#%d", p.Type, p.Index)
+	}
+	return p.Type
+}
+
+// indexProviders sets the index of the unnamed providers whose type is shared
+// with other unnamed providers, so every provider has a different ID.
+func indexProviders(providers []ProviderConfig) {
+	unnamed := map[string]int{}
+	for _, p := range providers {
+		if p.Name == "" {
+			unnamed[p.Type]++
+		}
+	}
+	for i := range providers {
+		if providers[i].Name == "" && unnamed[providers[i].Type] > 1 {
+			providers[i].Index = i + 1
+		}
+	}
+}
+
+// References returns the names of the providers referenced in the string
+// values of the provider configuration.
+func (p ProviderConfig) References() (providers []string) {
+	seen := map[string]bool{}
+	mapStrings(reflect.ValueOf(p), func(value string) (string, error) {
+		for _, matches := range reference.FindAllStringSubmatch(value, -1) {
+			if !seen[matches[1]] {
+				seen[matches[1]] = true
+				providers = append(providers, matches[1])
+			}
+		}
+		return value, nil
+	})
+	return
+}
+
+// Resolve returns a copy of the provider configuration with the references to
+// other providers replaced by their outputs.
+func (p ProviderConfig) Resolve(outputs Outputs) (ProviderConfig, error) {
+	value, err := mapStrings(reflect.ValueOf(p), func(value string) (string, error) {
+		var err error
+		result := reference.ReplaceAllStringFunc(value, func(ref string) string {
+			matches := reference.FindStringSubmatch(ref)
+			output, ok := outputs[matches[1]][matches[2]]
+			if !ok && err == nil {
+				err = fmt.Errorf("unresolvable reference %s in provider %s", ref, p.ID())
+			}
+			return output
+		})
+		return result, err
+	})
+	if err != nil {
+		return p, err
+	}
+	return value.Interface().(ProviderConfig), nil
+}
+
+// mapStrings returns a copy of value with fn applied to every string it
+// contains, including the ones in slices, maps and nested structs.
+func mapStrings(value reflect.Value, fn func(string) (string, error)) (reflect.Value, error) {
+	result := reflect.New(value.Type()).Elem()
+	switch value.Kind() {
+	case reflect.String:
+		s, err := fn(value.String())
+		if err != nil {
+			return result, err
+		}
+		result.SetString(s)
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field, err := mapStrings(value.Field(i), fn)
+			if err != nil {
+				return result, err
+			}
+			result.Field(i).Set(field)
+		}
+	case reflect.Slice:
+		if value.IsNil() {
+			return value, nil
+		}
+		result.Set(reflect.MakeSlice(value.Type(), value.Len(), value.Len()))
+		for i := 0; i < value.Len(); i++ {
+			item, err := mapStrings(value.Index(i), fn)
+			if err != nil {
+				return result, err
+			}
+			result.Index(i).Set(item)
+		}
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value, nil
+		}
+		elem, err := mapStrings(value.Elem(), fn)
+		if err != nil {
+			return result, err
+		}
+		result.Set(reflect.New(value.Type().Elem()))
+		result.Elem().Set(elem)
+	case reflect.Map:
+		if value.IsNil() {
+			return value, nil
+		}
+		result.Set(reflect.MakeMap(value.Type()))
+		for _, key := range value.MapKeys() {
+			item, err := mapStrings(value.MapIndex(key), fn)
+			if err != nil {
+				return result, err
+			}
+			result.SetMapIndex(key, item)
+		}
+	default:
+		result.Set(value)
+	}
+	return result, nil
+}
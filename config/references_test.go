@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfigUnnamedProviderIDs(t *testing.T) {
+	folder := t.TempDir()
+	content := `
+- name: dev
+  providers:
+  - type: text
+    method: data
+  - type: text
+    method: data
+  - type: secret
+  - type: text
+    name: extra
+`
+	if err := os.WriteFile(filepath.Join(folder, "profiles.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := ReadConfig(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, p := range profiles["dev"].Providers {
+		ids = append(ids, p.ID())
+	}
+	expected := []string{"text#1", "text#2", "secret", "extra"}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Fatalf("expected ids %v, got %v", expected, ids)
+		}
+	}
+}
+
+func TestValidateDuplicateProviderNames(t *testing.T) {
+	folder := t.TempDir()
+	content := `
+- name: dev
+  providers:
+  - type: text
+    name: same
+  - type: secret
+    name: same
+`
+	if err := os.WriteFile(filepath.Join(folder, "profiles.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	errs, err := Validate(folder, Checks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Line != 7 {
+		t.Fatalf("expected a duplicate provider error in line 7, got %v", errs)
+	}
+}
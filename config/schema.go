@@ -0,0 +1,183 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+)
+
+// SchemaSpec describes the configuration accepted by a provider type, or by
+// vault, with the type of its config and the keys of it used by each method.
+// The empty method applies to all of them. Required fields are left to
+// validate, as profiles extending others are allowed to be partial, except
+// the alternatives, config keys of which one is always needed.
+type SchemaSpec struct {
+	Methods      []string
+	Config       interface{}
+	Keys         map[string][]string
+	Alternatives map[string][][]string
+}
+
+type schema map[string]interface{}
+
+// Schema returns the JSON Schema of the profiles files, with the config of
+// vault and of each provider type depending on its type and method.
+func Schema(vault SchemaSpec, providers map[string]SchemaSpec) map[string]interface{} {
+	profile := typeSchema(reflect.TypeOf(ClusterConfig{}))
+	profile["required"] = []string{"name"}
+	properties := profile["properties"].(schema)
+	properties["vault"] = schema{"$ref": "#/$defs/vault"}
+	properties["providers"] = schema{"type": "array", "items": schema{"$ref": "#/$defs/provider"}}
+
+	vaultSchema := typeSchema(reflect.TypeOf(VaultConfig{}))
+	vaultSchema["allOf"] = methodConditions(nil, vault)
+
+	provider := typeSchema(reflect.TypeOf(ProviderConfig{}))
+	// A provider overriding one of an extended profile can be given by its
+	// name only.
+	provider["anyOf"] = []schema{{"required": []string{"name"}}, {"required": []string{"type"}}}
+	types := []string{}
+	conditions := []schema{}
+	for providerType := range providers {
+		types = append(types, providerType)
+	}
+	sort.Strings(types)
+	for _, providerType := range types {
+		conditions = append(conditions, methodConditions(schema{"type": schema{"const": providerType}}, providers[providerType])...)
+	}
+	// Any other type is run as a plugin binary.
+	provider["properties"].(schema)["type"] = schema{"anyOf": []schema{
+		{"enum": types},
+		{"type": "string", "description": "plugin provider, run as the clusterprofile-provider-<type> binary"},
+	}}
+	provider["allOf"] = conditions
+
+	return schema{
+		"$schema": schemaDraft,
+		"title":   "clusterprofile profiles",
+		"oneOf": []schema{
+			{"type": "array", "items": schema{"$ref": "#/$defs/profile"}},
+			{
+				"type": "object",
+				"properties": schema{
+					"vars":     schema{"type": "object", "additionalProperties": schema{"type": "string"}},
+					"profiles": schema{"type": "array", "items": schema{"$ref": "#/$defs/profile"}},
+				},
+				"additionalProperties": false,
+			},
+		},
+		"$defs": schema{"profile": profile, "vault": vaultSchema, "provider": provider},
+	}
+}
+
+// methodConditions returns the if/then conditions restricting the method and
+// the config for the node matching the discriminator.
+func methodConditions(discriminator schema, spec SchemaSpec) []schema {
+	conditions := []schema{}
+	when := func(method string) schema {
+		properties := schema{}
+		required := []string{}
+		for key, value := range discriminator {
+			properties[key] = value
+			required = append(required, key)
+		}
+		if method != "" {
+			properties["method"] = schema{"const": method}
+			required = append(required, "method")
+		}
+		sort.Strings(required)
+		return schema{"properties": properties, "required": required}
+	}
+
+	methods := spec.Methods
+	if len(methods) == 0 {
+		methods = []string{""}
+	} else {
+		conditions = append(conditions, schema{"if": when(""), "then": schema{
+			"properties": schema{"method": schema{"enum": spec.Methods}},
+		}})
+	}
+	for _, method := range methods {
+		var keys []string
+		if spec.Keys != nil {
+			keys = append(append([]string{}, spec.Keys[""]...), spec.Keys[method]...)
+		}
+		config := configSchema(spec.Config, keys)
+		groups := spec.Alternatives[""]
+		if method != "" {
+			groups = append(append([][]string{}, groups...), spec.Alternatives[method]...)
+		}
+		required := []schema{}
+		for _, alternatives := range groups {
+			anyOf := []schema{}
+			for _, key := range alternatives {
+				anyOf = append(anyOf, schema{"required": []string{key}})
+			}
+			required = append(required, schema{"anyOf": anyOf})
+		}
+		if len(required) > 0 {
+			config["allOf"] = required
+		}
+		then := schema{"properties": schema{"config": config}}
+		conditions = append(conditions, schema{"if": when(method), "then": then})
+	}
+	return conditions
+}
+
+func configSchema(config interface{}, keys []string) schema {
+	if config == nil {
+		return schema{"type": "object"}
+	}
+	full := typeSchema(reflect.TypeOf(config))["properties"].(schema)
+	if keys == nil {
+		return schema{"type": "object", "properties": full, "additionalProperties": false}
+	}
+	properties := schema{}
+	for _, key := range keys {
+		properties[key] = full[key]
+	}
+	return schema{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// typeSchema returns the schema of a Go type using the yaml names of the
+// struct fields.
+func typeSchema(t reflect.Type) schema {
+	if t == reflect.TypeOf(yaml.Node{}) {
+		return schema{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := schema{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			properties[name] = typeSchema(t.Field(i).Type)
+		}
+		return schema{"type": "object", "properties": properties, "additionalProperties": false}
+	default:
+		return schema{}
+	}
+}
@@ -0,0 +1,155 @@
+package config_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/smorenodp/clusterprofile/config"
+	"github.com/smorenodp/clusterprofile/providers"
+	"gopkg.in/yaml.v3"
+)
+
+type object = map[string]interface{}
+
+// testSchema returns the schema generated for the specs of the providers
+// registered, as decoded from its JSON.
+func testSchema(t *testing.T) object {
+	t.Helper()
+	content, err := json.Marshal(config.Schema(providers.SchemaSpecs()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s object
+	if err = json.Unmarshal(content, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// missingFields returns the yaml fields of the type, including the ones of
+// nested structs, without a property in the schema, following the references
+// to the definitions of defs.
+func missingFields(t reflect.Type, s object, defs object, path string) (missing []string) {
+	if ref, ok := s["$ref"].(string); ok {
+		s, _ = defs[strings.TrimPrefix(ref, "#/$defs/")].(object)
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+		if items, ok := s["items"].(object); ok {
+			s = items
+		} else if values, ok := s["additionalProperties"].(object); ok {
+			s = values
+		}
+		if ref, ok := s["$ref"].(string); ok {
+			s, _ = defs[strings.TrimPrefix(ref, "#/$defs/")].(object)
+		}
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(yaml.Node{}) {
+		return nil
+	}
+	properties, _ := s["properties"].(object)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		property, ok := properties[name].(object)
+		if !ok {
+			missing = append(missing, path+name)
+			continue
+		}
+		missing = append(missing, missingFields(t.Field(i).Type, property, defs, path+name+".")...)
+	}
+	return
+}
+
+// configProperties merges the properties of config allowed by every method of
+// the conditions matching the discriminator.
+func configProperties(conditions []interface{}, key string, value string) object {
+	merged := object{}
+	for _, c := range conditions {
+		condition := c.(object)
+		when, _ := condition["if"].(object)
+		properties, _ := when["properties"].(object)
+		if key != "" {
+			if match, _ := properties[key].(object); match == nil || match["const"] != value {
+				continue
+			}
+		}
+		then, _ := condition["then"].(object)
+		thenProperties, _ := then["properties"].(object)
+		configSchema, _ := thenProperties["config"].(object)
+		configProperties, _ := configSchema["properties"].(object)
+		for name, property := range configProperties {
+			merged[name] = property
+		}
+	}
+	return merged
+}
+
+func TestSchemaHasEveryField(t *testing.T) {
+	s := testSchema(t)
+	defs := s["$defs"].(object)
+	for name, value := range map[string]interface{}{"profile": config.ClusterConfig{}, "vault": config.VaultConfig{}, "provider": config.ProviderConfig{}} {
+		for _, field := range missingFields(reflect.TypeOf(value), defs[name].(object), defs, "") {
+			t.Errorf("field %s of %s missing in the schema", field, name)
+		}
+	}
+
+	vaultSpec, specs := providers.SchemaSpecs()
+	vaultConfig := object{"properties": configProperties(defs["vault"].(object)["allOf"].([]interface{}), "", "")}
+	for _, field := range missingFields(reflect.TypeOf(vaultSpec.Config), vaultConfig, defs, "") {
+		t.Errorf("field config.%s of vault not used by any method in the schema", field)
+	}
+
+	conditions := defs["provider"].(object)["allOf"].([]interface{})
+	for providerType, spec := range specs {
+		if spec.Config == nil {
+			continue
+		}
+		providerConfig := object{"properties": configProperties(conditions, "type", providerType)}
+		for _, field := range missingFields(reflect.TypeOf(spec.Config), providerConfig, defs, "") {
+			t.Errorf("field config.%s of provider %s not used by any method in the schema", field, providerType)
+		}
+	}
+}
+
+func TestSchemaKeysExist(t *testing.T) {
+	vaultSpec, specs := providers.SchemaSpecs()
+	specs["vault"] = vaultSpec
+	for name, spec := range specs {
+		if spec.Config == nil {
+			continue
+		}
+		fields := map[string]bool{}
+		configType := reflect.TypeOf(spec.Config)
+		for i := 0; i < configType.NumField(); i++ {
+			fields[strings.Split(configType.Field(i).Tag.Get("yaml"), ",")[0]] = true
+		}
+		for method, keys := range spec.Keys {
+			for _, key := range keys {
+				if !fields[key] {
+					t.Errorf("key %s of method %q of %s is not a field of its config", key, method, name)
+				}
+			}
+		}
+	}
+}
+
+func TestSchemaProviderNameOrType(t *testing.T) {
+	provider := testSchema(t)["$defs"].(object)["provider"].(object)
+	if _, ok := provider["required"]; ok {
+		t.Fatalf("expected no required field for the providers, got %v", provider["required"])
+	}
+	required := []string{}
+	for _, alternative := range provider["anyOf"].([]interface{}) {
+		for _, key := range alternative.(object)["required"].([]interface{}) {
+			required = append(required, key.(string))
+		}
+	}
+	if strings.Join(required, ",") != "name,type" {
+		t.Fatalf("expected a provider to require its name or type, got %v", required)
+	}
+}
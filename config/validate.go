@@ -0,0 +1,353 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	typeErrorRegex    = "^line (?P<line>\\d+): (?P<msg>.*)$"
+	unknownFieldRegex = "^field (?P<field>\\S+) not found"
+)
+
+// ConfigError is a problem in the profiles configuration located in a file.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// FieldError is a problem found in a field of the configuration, the key is
+// the path of the field relative to the checked node (e.g. config.role).
+type FieldError struct {
+	Key string
+	Msg string
+}
+
+// Checks validates the configuration that depends on the implemented login
+// methods and providers.
+type Checks struct {
+	Vault    func(VaultConfig) []FieldError
+	Provider func(ProviderConfig) []FieldError
+}
+
+type fileConfig struct {
+	Vars     map[string]string `yaml:"vars"`
+	Profiles []ClusterConfig   `yaml:"profiles"`
+}
+
+// Validate checks every profile of the folder, returning all the problems
+// found. The error is only set when the folder can't be read.
+func Validate(folder string, checks Checks) (errs []ConfigError, err error) {
+	files, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	fileRegex := regexp.MustCompile(yamlRegex)
+	l := &loader{profiles: make(map[string]profileNode), origins: make(map[*yaml.Node]string)}
+	for _, f := range files {
+		if !fileRegex.MatchString(f.Name()) {
+			continue
+		}
+		file := fmt.Sprintf("%s/%s", folder, f.Name())
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = l.loadFile(file, content); err != nil {
+			errs = append(errs, asConfigError(file, err))
+			continue
+		}
+		errs = append(errs, strictDecode(file, content)...)
+	}
+
+	errs = append(errs, l.duplicateErrors()...)
+
+	profiles := map[string]ClusterConfig{}
+	nodes := map[string]*yaml.Node{}
+	for name, p := range l.profiles {
+		node, err := l.render(name)
+		if err != nil {
+			errs = append(errs, asConfigError(p.file, err))
+			continue
+		}
+		var profile ClusterConfig
+		if err = node.Decode(&profile); err != nil {
+			errs = append(errs, asConfigError(p.file, err))
+			continue
+		}
+		indexProviders(profile.Providers)
+		profiles[name], nodes[name] = profile, node
+	}
+
+	for name, profile := range profiles {
+		node := nodes[name]
+		vault := mappingValue(node, "vault")
+		if pivot := profile.Vault.PivotProfile; pivot != "" {
+			if _, ok := l.profiles[pivot]; !ok {
+				errs = append(errs, l.locate(vault, "pivoting_profile", fmt.Sprintf("pivoting profile %s does not exist", pivot)))
+			} else if _, err := PivotChain(profiles, name); err != nil {
+				errs = append(errs, l.locate(vault, "pivoting_profile", err.Error()))
+			}
+		}
+		if checks.Vault != nil {
+			for _, fe := range checks.Vault(profile.Vault) {
+				errs = append(errs, l.locate(vault, fe.Key, fe.Msg))
+			}
+		}
+		providers := mappingValue(node, "providers")
+		ids := map[string]bool{}
+		for i, p := range profile.Providers {
+			if ids[p.ID()] {
+				errs = append(errs, l.locate(providers.Content[i], "name", fmt.Sprintf("duplicate provider %s, give it a different name", p.ID())))
+			}
+			ids[p.ID()] = true
+		}
+		if checks.Provider != nil {
+			for i, p := range profile.Providers {
+				for _, fe := range checks.Provider(p) {
+					errs = append(errs, l.locate(providers.Content[i], fe.Key, fe.Msg))
+				}
+			}
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return errs, nil
+}
+
+// PivotChain returns the profile followed by its pivoting profiles, in the
+// order they are used to authenticate against each other.
+func PivotChain(profiles map[string]ClusterConfig, name string) (chain []string, err error) {
+	visited := map[string]bool{}
+	for current := name; current != ""; current = profiles[current].Vault.PivotProfile {
+		chain = append(chain, current)
+		if visited[current] {
+			return nil, fmt.Errorf("pivot cycle detected: %s", strings.Join(chain, " -> "))
+		}
+		visited[current] = true
+		if _, ok := profiles[current]; !ok {
+			return nil, fmt.Errorf("Error loading profile config %s", current)
+		}
+	}
+	return
+}
+
+// duplicateErrors returns an error for each profile defined more than once,
+// sorted by name.
+func (l *loader) duplicateErrors() (errs []ConfigError) {
+	names := make([]string, 0, len(l.duplicates))
+	for name := range l.duplicates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, l.profileDuplicates(name)...)
+	}
+	return
+}
+
+// profileDuplicates returns an error for each other definition of the
+// profile.
+func (l *loader) profileDuplicates(name string) (errs []ConfigError) {
+	first := l.profiles[name]
+	for _, d := range l.duplicates[name] {
+		errs = append(errs, ConfigError{File: d.file, Line: d.node.Line, Column: d.node.Column,
+			Msg: fmt.Sprintf("duplicate profile %s, also defined in %s:%d:%d", name, first.file, first.node.Line, first.node.Column)})
+	}
+	return
+}
+
+func asConfigError(file string, err error) ConfigError {
+	if configErr, ok := err.(ConfigError); ok {
+		return configErr
+	}
+	return ConfigError{File: file, Msg: err.Error()}
+}
+
+// locate returns an error positioned in the field of node with the key path,
+// or in the closest parent present when the field is missing.
+func (l *loader) locate(node *yaml.Node, key string, msg string) ConfigError {
+	for _, part := range strings.Split(key, ".") {
+		value := mappingValue(node, part)
+		if value == nil {
+			break
+		}
+		node = value
+	}
+	if node == nil {
+		return ConfigError{Msg: msg}
+	}
+	return ConfigError{File: l.origins[node], Line: node.Line, Column: node.Column, Msg: msg}
+}
+
+// strictDecode decodes the file rejecting the unknown fields, so typos in the
+// keys don't go unnoticed.
+func strictDecode(file string, content []byte) (errs []ConfigError) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 {
+		return
+	}
+	var target interface{} = &[]ClusterConfig{}
+	if doc.Content[0].Kind == yaml.MappingNode {
+		target = &fileConfig{}
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err := decoder.Decode(target)
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		if err != nil {
+			errs = append(errs, ConfigError{File: file, Msg: err.Error()})
+		}
+		return
+	}
+	lineRegex := regexp.MustCompile(typeErrorRegex)
+	fieldRegex := regexp.MustCompile(unknownFieldRegex)
+	for _, msg := range typeErr.Errors {
+		matches := lineRegex.FindStringSubmatch(msg)
+		if matches == nil {
+			errs = append(errs, ConfigError{File: file, Msg: msg})
+			continue
+		}
+		line, _ := strconv.Atoi(matches[1])
+		key := ""
+		if field := fieldRegex.FindStringSubmatch(matches[2]); field != nil {
+			key = field[1]
+		}
+		errs = append(errs, ConfigError{File: file, Line: line, Column: findColumn(&doc, line, key), Msg: matches[2]})
+	}
+	return
+}
+
+func findColumn(node *yaml.Node, line int, key string) int {
+	if node.Line == line && (key == "" || node.Value == key) {
+		return node.Column
+	}
+	for _, child := range node.Content {
+		if column := findColumn(child, line, key); column != 0 {
+			return column
+		}
+	}
+	return 0
+}
+
+// UnknownKeyError is returned when decoding a config with a key that doesn't
+// exist in its type, the path holds the parent keys of it.
+type UnknownKeyError struct {
+	Path     string
+	Line     int
+	Column   int
+	Expected []string
+}
+
+func (e UnknownKeyError) Message() string {
+	return fmt.Sprintf("unknown key %s, expected one of: %s", e.Path, strings.Join(e.Expected, ", "))
+}
+
+func (e UnknownKeyError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message())
+}
+
+// checkKnownFields returns an error for the first key of node that doesn't
+// match a yaml field of the type t.
+func checkKnownFields(node *yaml.Node, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.AliasNode:
+		return checkKnownFields(node.Alias, t, path)
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			fields[strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]] = t.Field(i).Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			keyPath := strings.TrimPrefix(fmt.Sprintf("%s.%s", path, key.Value), ".")
+			field, ok := fields[key.Value]
+			if !ok {
+				return UnknownKeyError{Path: keyPath, Line: key.Line, Column: key.Column, Expected: sortedKeys(fields)}
+			}
+			if err := checkKnownFields(node.Content[i+1], field, keyPath); err != nil {
+				return err
+			}
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := checkKnownFields(item, t.Elem(), path); err != nil {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := checkKnownFields(node.Content[i+1], t.Elem(), fmt.Sprintf("%s.%s", path, node.Content[i].Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(fields map[string]reflect.Type) []string {
+	keys := []string{}
+	for key := range fields {
+		if key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsEmpty reports if the field of value with the yaml key path is empty.
+func IsEmpty(value interface{}, key string) bool {
+	v := reflect.ValueOf(value)
+	for _, part := range strings.Split(key, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return true
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return true
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0] == part {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return v.IsZero()
+}
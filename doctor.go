@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/smorenodp/clusterprofile/config"
+	"github.com/smorenodp/clusterprofile/providers"
+)
+
+// doctor prints a line for each check of a profile, with a hint to fix the
+// ones that fail.
+type doctor struct {
+	failed int
+}
+
+func (d *doctor) pass(format string, a ...interface{}) {
+	fmt.Printf("[ok]   %s\n", fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) skip(format string, a ...interface{}) {
+	fmt.Printf("[skip] %s\n", fmt.Sprintf(format, a...))
+}
+
+func (d *doctor) fail(hint string, format string, a ...interface{}) {
+	d.failed++
+	fmt.Printf("[fail] %s\n       hint: %s\n", fmt.Sprintf(format, a...), hint)
+}
+
+// Doctor checks the vault of the profile, its cached token, the paths used by
+// its providers and the local prerequisites, without generating credentials.
+// It returns the number of failed checks.
+func (cp *ClusterProfile) Doctor(name string, banner config.Banner) int {
+	d := &doctor{}
+	pConfig, pCreds, err := cp.GetProfile(name)
+	if err != nil {
+		d.fail("check the profile name, clusterprofile validate lists the problems of the configuration", "profile %s not found", name)
+		return d.failed
+	}
+	if _, err = cp.PivotChain(name); err != nil {
+		d.fail("check the pivoting_profile of the profiles in the chain", "pivot chain - %s", err)
+	}
+
+	client := doctorVault(d, name, pConfig.Vault, pCreds)
+
+	ordered, err := orderProviders(pConfig.Providers)
+	if err != nil {
+		d.fail("fix the references between the providers", "providers - %s", err)
+		return d.failed
+	}
+	outputs := config.Outputs{}
+	for _, p := range ordered {
+		resolved, err := p.Resolve(outputs)
+		if err != nil {
+			d.fail("load the profile to generate the variables referenced", "provider %s - %s", p.ID(), err)
+			continue
+		}
+		p = resolved
+		provider, err := providers.NewProvider(client, p)
+		if err != nil {
+			d.fail("check its configuration with clusterprofile validate", "provider %s - %s", p.ID(), err)
+			continue
+		}
+		if provider == nil {
+			d.fail(fmt.Sprintf("install clusterprofile-provider-%s in the PATH", p.Type), "provider %s - type %s not implemented", p.ID(), p.Type)
+			continue
+		}
+		if consumer, ok := provider.(providers.OutputsConsumer); ok {
+			consumer.SetOutputs(outputs)
+		}
+		provider.LoadProfileCreds(pCreds)
+		if provider.CredsLoaded() {
+			outputs[p.ID()] = credsOutputs(provider.ProfileCreds())
+		}
+		if checker, ok := provider.(providers.Checker); ok {
+			for _, check := range checker.Check() {
+				if check.Err != nil {
+					d.fail(check.Hint, "provider %s: %s - %s", p.ID(), check.Name, check.Err)
+				} else {
+					d.pass("provider %s: %s", p.ID(), check.Name)
+				}
+			}
+		}
+		if user, ok := provider.(providers.VaultUser); ok {
+			for _, path := range user.VaultPaths() {
+				doctorPath(d, client, p.ID(), path)
+			}
+		}
+	}
+
+	if !banner.Enable {
+		d.skip("banner disabled")
+	} else if _, err = exec.LookPath(banner.Command); err != nil {
+		d.fail(fmt.Sprintf("install %s or change the command with --banner-cmd", banner.Command), "banner command %s not found", banner.Command)
+	} else {
+		d.pass("banner command %s", banner.Command)
+	}
+	return d.failed
+}
+
+// doctorVault checks that vault is reachable and unsealed and the cached
+// token is valid. It returns the client with the token, or nil without one.
+func doctorVault(d *doctor, name string, vault config.VaultConfig, pCreds []string) *providers.VaultClient {
+	if vault.Method == "oidc" {
+		if _, err := exec.LookPath("vault"); err != nil {
+			d.fail("install the vault CLI, the oidc login runs vault login", "vault CLI not found")
+		} else {
+			d.pass("vault CLI")
+		}
+	}
+	client, err := providers.NewVaultClient(vault)
+	if err != nil {
+		d.fail("check the tls configuration of vault", "vault client - %s", err)
+		return nil
+	}
+	health, err := client.Sys().Health()
+	if err != nil {
+		d.fail("check the vault addr, the network or VPN and the tls configuration", "vault %s not reachable - %s", vault.Addr, err)
+		d.skip("vault token and provider paths")
+		return nil
+	}
+	if !health.Initialized || health.Sealed {
+		d.fail("ask the vault operators to unseal it", "vault %s is sealed or not initialized", vault.Addr)
+		d.skip("vault token and provider paths")
+		return nil
+	}
+	d.pass("vault %s is reachable and unsealed (version %s)", vault.Addr, health.Version)
+
+	if !client.LoadProfileCreds(pCreds) && !client.StaticToken() {
+		d.fail(fmt.Sprintf("run clusterprofile load -p %s to log in", name), "no valid cached vault token")
+		d.skip("provider paths")
+		return nil
+	}
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		d.fail(fmt.Sprintf("the token was revoked, run clusterprofile remove -p %s and load it again", name), "vault token lookup - %s", err)
+		d.skip("provider paths")
+		return nil
+	}
+	policies, _ := secret.TokenPolicies()
+	ttl, _ := secret.TokenTTL()
+	d.pass("vault token valid for %s with policies %s", ttl, strings.Join(policies, ", "))
+	return client
+}
+
+// doctorPath checks that the mount of the path exists and the token has the
+// capability the provider needs on it.
+func doctorPath(d *doctor, client *providers.VaultClient, id string, path providers.VaultPath) {
+	if client == nil {
+		return
+	}
+	mount, capabilities, err := client.CheckPath(path)
+	if err != nil {
+		d.fail("check the backend of the provider, the secrets engine must be enabled and visible to the token", "provider %s: mount of %s - %s", id, path, err)
+		return
+	}
+	for _, capability := range capabilities {
+		if capability == path.Capability || capability == "root" {
+			d.pass("provider %s: %s %s in mount %s", id, path.Capability, path, mount)
+			return
+		}
+	}
+	d.fail(fmt.Sprintf("check that the role exists and the policies of the token grant %s on the path", path.Capability),
+		"provider %s: no %s capability on %s (%s)", id, path.Capability, path, strings.Join(capabilities, ", "))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+func TestDoctor(t *testing.T) {
+	kv2 := map[string]interface{}{"data": map[string]interface{}{"path": "secret/", "type": "kv", "options": map[string]interface{}{"version": "2"}}}
+	server, requests := testVault(t, map[string]interface{}{
+		"/v1/sys/health":                                map[string]interface{}{"initialized": true, "sealed": false, "version": "1.15.0"},
+		"/v1/auth/token/lookup-self":                    map[string]interface{}{"data": map[string]interface{}{"policies": []string{"default"}, "ttl": 3600}},
+		"/v1/sys/internal/ui/mounts/secret/app":         kv2,
+		"/v1/sys/internal/ui/mounts/database/creds/app": http.StatusForbidden,
+		"/v1/sys/capabilities-self":                     map[string]interface{}{"data": map[string]interface{}{"capabilities": []string{"read"}}},
+	})
+	args := testArgs(t, "dev", fmt.Sprintf(`
+- name: dev
+  vault:
+    addr: %s
+    method: token
+    config:
+      token: static
+  providers:
+  - type: secret
+    config:
+      path: secret/app
+      secret_map:
+        password: PASSWORD
+      metadata_map:
+        updated_time: PASSWORD_UPDATED
+  - type: database
+    config:
+      role: app
+`, server.URL))
+
+	cp, err := NewClusterProfile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := cp.Doctor("dev", config.Banner{}); failed != 1 {
+		t.Fatalf("expected only the database mount to fail, got %d failures", failed)
+	}
+	checks := 0
+	for _, request := range *requests {
+		if request == "POST /v1/sys/capabilities-self" {
+			checks++
+		}
+	}
+	if checks != 2 {
+		t.Fatalf("expected the data and the metadata of the secret to be checked, got %v", *requests)
+	}
+}
+
+func TestDoctorUnresolvedReference(t *testing.T) {
+	server, _ := testVault(t, map[string]interface{}{
+		"/v1/sys/health":             map[string]interface{}{"initialized": true, "sealed": false, "version": "1.15.0"},
+		"/v1/auth/token/lookup-self": map[string]interface{}{"data": map[string]interface{}{"policies": []string{"default"}, "ttl": 3600}},
+	})
+	args := testArgs(t, "dev", fmt.Sprintf(`
+- name: dev
+  vault:
+    addr: %s
+    method: token
+    config:
+      token: static
+  providers:
+  - type: text
+    name: dbinfo
+    method: data
+    config:
+      data: DB_HOST=db
+  - type: text
+    name: app
+    method: data
+    config:
+      data: DATABASE_URL=postgres://${providers.dbinfo.DB_HOST}:5432/app
+`, server.URL))
+	cp, err := NewClusterProfile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed int
+	output, _ := captureStdout(t, func() error {
+		failed = cp.Doctor("dev", config.Banner{})
+		return nil
+	})
+	if failed != 1 || !strings.Contains(output, "[fail] provider app - unresolvable reference") {
+		t.Fatalf("expected the reference of the provider app to fail, got %d failures\n%s", failed, output)
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/hashicorp/vault/api v1.12.2
 	github.com/tobischo/gokeepasslib/v3 v3.6.0
 	github.com/urfave/cli/v3 v3.0.0-alpha9
+	golang.org/x/crypto v0.22.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -26,7 +27,6 @@ require (
 	github.com/ryanuber/go-glob v1.0.0 // indirect
 	github.com/tobischo/argon2 v0.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/net v0.21.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
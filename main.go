@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/smorenodp/clusterprofile/config"
+	"github.com/smorenodp/clusterprofile/providers"
 	"github.com/urfave/cli/v3"
 )
 
@@ -19,7 +22,10 @@ type CommandArgs struct {
 	CredentialsFile string
 	ExecutableFile  string
 	Profile         string
+	Provider        string
 	Echo            bool
+	ShowChain       bool
+	DryRun          bool
 	Banner          config.Banner
 }
 
@@ -35,21 +41,40 @@ func getOrElse(env, valueDefault string) string {
 	}
 }
 
-func load(args CommandArgs) error {
+// loadProfile loads the credentials of the profile, generating them if they
+// don't exist or are expired, and saves them in the credentials file.
+func loadProfile(args CommandArgs) (*ClusterProfile, error) {
 	cp, err := NewClusterProfile(args)
 	if err != nil {
-		return fmt.Errorf("error generating clusterprofile - %s", err)
+		return nil, fmt.Errorf("error generating clusterprofile - %s", err)
 	}
 
 	err = cp.GenerateVaultClient()
 	if err != nil {
-		return fmt.Errorf("error generating vault client - %s", err)
+		return nil, fmt.Errorf("error generating vault client - %s", err)
 	}
 
-	cp.Run()
+	if err = cp.Run(); err != nil {
+		return nil, fmt.Errorf("error executing providers - %s", err)
+	}
 
 	if err = config.SaveCreds(args.CredentialsFile, cp.profilesCreds); err != nil {
-		return fmt.Errorf("error saving credentials in %s - %s", args.CredentialsFile, err)
+		return nil, fmt.Errorf("error saving credentials in %s - %s", args.CredentialsFile, err)
+	}
+	return cp, nil
+}
+
+func load(args CommandArgs) error {
+	if args.DryRun {
+		cp, err := NewClusterProfile(args)
+		if err != nil {
+			return fmt.Errorf("error generating clusterprofile - %s", err)
+		}
+		return cp.Plan()
+	}
+	cp, err := loadProfile(args)
+	if err != nil {
+		return err
 	}
 
 	if args.Echo {
@@ -60,21 +85,60 @@ func load(args CommandArgs) error {
 	return nil
 }
 
-func show(args CommandArgs) error {
-
+// awsCredentialProcess prints the credentials of an aws provider for the
+// credential_process of the AWS SDKs. Its output must be the JSON only, so it
+// uses the cached credentials or generates just that provider.
+func awsCredentialProcess(args CommandArgs) error {
 	cp, err := NewClusterProfile(args)
 	if err != nil {
 		return fmt.Errorf("error generating clusterprofile - %s", err)
 	}
-	_, creds, err := cp.GetProfile(args.Profile)
+	provider, generated, err := cp.LoadProvider(args.Provider)
+	if err != nil {
+		return err
+	}
+	aws, ok := provider.(*providers.AWSProvider)
+	if !ok {
+		return fmt.Errorf("provider %s is not an aws provider", args.Provider)
+	}
+	content, err := aws.CredentialProcess()
+	if err != nil {
+		return err
+	}
+	if generated {
+		if err = config.SaveCreds(args.CredentialsFile, cp.profilesCreds); err != nil {
+			return fmt.Errorf("error saving credentials in %s - %s", args.CredentialsFile, err)
+		}
+	}
+	fmt.Println(string(content))
+	return nil
+}
 
+func show(args CommandArgs) error {
+
+	cp, err := NewClusterProfile(args)
 	if err != nil {
-		return fmt.Errorf("error getting profile - %s", err)
+		return fmt.Errorf("error generating clusterprofile - %s", err)
+	}
+	profiles := []string{args.Profile}
+	if args.ShowChain {
+		if profiles, err = cp.PivotChain(args.Profile); err != nil {
+			return fmt.Errorf("error getting pivot chain - %s", err)
+		}
+		fmt.Printf("# %s\n", strings.Join(profiles, " -> "))
 	}
-	if len(creds) > 0 {
-		fmt.Printf("[%s]\n", args.Profile)
-		for _, c := range creds {
-			fmt.Println(c)
+
+	for _, name := range profiles {
+		_, creds, err := cp.GetProfile(name)
+
+		if err != nil {
+			return fmt.Errorf("error getting profile - %s", err)
+		}
+		if len(creds) > 0 {
+			fmt.Printf("[%s]\n", name)
+			for _, c := range creds {
+				fmt.Println(c)
+			}
 		}
 	}
 
@@ -87,6 +151,9 @@ func remove(args CommandArgs) error {
 	if err != nil {
 		return fmt.Errorf("error generating clusterprofile - %s", err)
 	}
+	if err = cp.ReleaseProviders(args.Profile); err != nil {
+		errorLog.Printf("Error releasing credentials of profile %s - %s\n", args.Profile, err)
+	}
 	err = cp.RemoveProfile(args.Profile)
 
 	if err != nil {
@@ -100,6 +167,50 @@ func remove(args CommandArgs) error {
 	return nil
 }
 
+func render(args CommandArgs) error {
+	content, err := config.RenderConfig(args.ProfilesConfig, args.Profile)
+	if err != nil {
+		return fmt.Errorf("error rendering profile %s - %s", args.Profile, err)
+	}
+	fmt.Print(string(content))
+	return nil
+}
+
+func validate(args CommandArgs) error {
+	errs, err := config.Validate(args.ProfilesConfig, config.Checks{Vault: providers.CheckVault, Provider: providers.CheckProvider})
+	if err != nil {
+		return fmt.Errorf("error reading config folder %s - %s", args.ProfilesConfig, err)
+	}
+	for _, e := range errs {
+		errorLog.Println(e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problems found in %s", len(errs), args.ProfilesConfig)
+	}
+	fmt.Printf("Configuration in %s is valid\n", args.ProfilesConfig)
+	return nil
+}
+
+func runDoctor(args CommandArgs) error {
+	cp, err := NewClusterProfile(args)
+	if err != nil {
+		return fmt.Errorf("error generating clusterprofile - %s", err)
+	}
+	if failed := cp.Doctor(args.Profile, args.Banner); failed > 0 {
+		return fmt.Errorf("%d checks failed for profile %s", failed, args.Profile)
+	}
+	return nil
+}
+
+func printSchema() error {
+	content, err := json.MarshalIndent(config.Schema(providers.SchemaSpecs()), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating schema - %s", err)
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
 func main() {
 	var args CommandArgs = CommandArgs{}
 	home, err := os.UserHomeDir()
@@ -116,6 +227,7 @@ func main() {
 				Value:       getOrElse("CLUSTERID_CONFIG_FOLDER", fmt.Sprintf("%s/.clusterid/profiles/", home)),
 				Usage:       "Config folder for program.",
 				Destination: &args.ProfilesConfig,
+				Persistent:  true,
 			},
 			&cli.StringFlag{
 				Name:        "creds",
@@ -123,6 +235,7 @@ func main() {
 				Value:       getOrElse("CLUSTERID_PROFILE_FILE", fmt.Sprintf("%s/.clusterid/credentials", home)),
 				Usage:       "Creds file for program.",
 				Destination: &args.CredentialsFile,
+				Persistent:  true,
 			},
 			&cli.StringFlag{
 				Name:        "exec",
@@ -130,6 +243,7 @@ func main() {
 				Value:       getOrElse("CLUSTERID_EXEC_FILE", fmt.Sprintf("%s/.clusterid/export.sh", home)),
 				Usage:       "Bash file to export the configuration for the profile.",
 				Destination: &args.ExecutableFile,
+				Persistent:  true,
 			},
 			&cli.StringFlag{
 				Name:        "profile",
@@ -137,6 +251,7 @@ func main() {
 				Value:       getOrElse("PROFILE_NAME", ""),
 				Usage:       "Name of the profile to load",
 				Destination: &args.Profile,
+				Persistent:  true,
 			},
 			&cli.BoolFlag{
 				Name:        "echo",
@@ -170,6 +285,14 @@ func main() {
 				Name:    "load",
 				Aliases: []string{"l"},
 				Usage:   "Load credentials for profile. Generate them if they don't exist or are expired.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Value:       false,
+						Usage:       "Show what would be reused, renewed or generated without doing it",
+						Destination: &args.DryRun,
+					},
+				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 					return load(args)
 				},
@@ -178,6 +301,14 @@ func main() {
 				Name:    "show",
 				Aliases: []string{"s"},
 				Usage:   "Show credencials if exist",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "chain",
+						Value:       false,
+						Usage:       "Show the credentials of every profile in the pivot chain",
+						Destination: &args.ShowChain,
+					},
+				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 					return show(args)
 				},
@@ -190,6 +321,55 @@ func main() {
 					return remove(args)
 				},
 			},
+			{
+				Name:  "aws-credential-process",
+				Usage: "Print the credentials of an aws provider for the credential_process of the AWS SDKs",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "provider",
+						Usage:       "Name of the aws provider in the profile",
+						Value:       "aws",
+						Destination: &args.Provider,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return awsCredentialProcess(args)
+				},
+			},
+			{
+				Name:  "validate",
+				Usage: "Validate the profiles configuration",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return validate(args)
+				},
+			},
+			{
+				Name:  "doctor",
+				Usage: "Check vault, the cached token, the providers and the local prerequisites of the profile",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return runDoctor(args)
+				},
+			},
+			{
+				Name:  "schema",
+				Usage: "Print the JSON Schema of the profiles configuration",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return printSchema()
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Inspect the profiles configuration",
+				Commands: []*cli.Command{
+					{
+						Name:  "render",
+						Usage: "Print the profile with all the profiles it extends merged",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							return render(args)
+						},
+					},
+				},
+			},
 		},
 	}
 
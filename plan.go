@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+	"github.com/smorenodp/clusterprofile/providers"
+)
+
+// Plan prints what loading the profile would do, without logging in,
+// generating credentials or writing any file: whether the cached credentials
+// of vault and each provider are reused, renewed or generated, and the vault
+// paths used to generate them.
+func (cp *ClusterProfile) Plan() error {
+	chain, err := cp.PivotChain(cp.profile.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# dry run of profile %s, nothing is generated or saved\n", cp.profile.Name)
+	client, err := cp.planVault(chain)
+	if err != nil {
+		return err
+	}
+
+	pConfig, pCreds, _ := cp.GetProfile(cp.profile.Name)
+	ordered, err := orderProviders(pConfig.Providers)
+	if err != nil {
+		return err
+	}
+	outputs := config.Outputs{}
+	for _, p := range ordered {
+		// The references to values not generated yet are kept unresolved, as
+		// they are shown in the paths.
+		if resolved, err := p.Resolve(outputs); err == nil {
+			p = resolved
+		}
+		provider, err := providers.NewProvider(client, p)
+		if err != nil {
+			return fmt.Errorf("error creating provider %s - %s", p.ID(), err)
+		}
+		if provider == nil {
+			planLine("provider", p.ID(), fmt.Sprintf("skip, type %s not implemented", p.Type))
+			continue
+		}
+		if consumer, ok := provider.(providers.OutputsConsumer); ok {
+			consumer.SetOutputs(outputs)
+		}
+		provider.LoadProfileCreds(pCreds)
+		state := providers.CacheState{Stored: provider.CredsLoaded()}
+		if inspector, ok := provider.(providers.CacheInspector); ok {
+			state = inspector.CacheState()
+		}
+		if provider.CredsLoaded() {
+			planLine("provider", p.ID(), planReuse(state.Expires))
+			outputs[p.ID()] = credsOutputs(provider.ProfileCreds())
+			continue
+		}
+		planLine("provider", p.ID(), planGenerate(state.Stored))
+		if user, ok := provider.(providers.VaultUser); ok {
+			for _, path := range user.VaultPaths() {
+				fmt.Printf("%-28s %-6s %s\n", "", path.Capability, path)
+			}
+		}
+	}
+	return nil
+}
+
+// planVault prints what loading the vault token of the first profile of the
+// chain would do, walking down the chain like loadVaultClient. The client
+// returned only has the cached token.
+func (cp *ClusterProfile) planVault(chain []string) (*providers.VaultClient, error) {
+	profile, creds, err := cp.GetProfile(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	client, err := providers.NewVaultClient(profile.Vault)
+	if err != nil {
+		return nil, err
+	}
+	if client.LoadProfileCreds(creds) {
+		planLine("vault", chain[0], planReuse(client.TTL))
+		return client, nil
+	}
+	if len(chain) > 1 {
+		if _, err = cp.planVault(chain[1:]); err != nil {
+			return nil, err
+		}
+	}
+	action := planGenerate(client.CacheState().Stored)
+	if profile.Vault.Method == "" {
+		action = "none, no login method configured"
+	} else if client.StaticToken() {
+		action = "use the configured token"
+	} else if len(chain) > 1 {
+		action += fmt.Sprintf(", logging in with method %s through profile %s", profile.Vault.Method, chain[1])
+	} else {
+		action += fmt.Sprintf(", logging in with method %s", profile.Vault.Method)
+	}
+	planLine("vault", chain[0], action)
+	return client, nil
+}
+
+func planLine(kind string, name string, action string) {
+	fmt.Printf("%-8s %-19s %s\n", kind, name, action)
+}
+
+func planReuse(expires time.Time) string {
+	if expires.IsZero() {
+		return "reuse"
+	}
+	return fmt.Sprintf("reuse, valid for %s (until %s)", time.Until(expires).Round(time.Second), expires.Format("2006-01-02 15:04:05"))
+}
+
+// planGenerate returns the action for the credentials stored but expired or
+// for the ones that were never stored.
+func planGenerate(stored bool) string {
+	if stored {
+		return "renew (expired)"
+	}
+	return "generate"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPlanDoesNotLoginOrWrite(t *testing.T) {
+	server, requests := testVault(t, map[string]interface{}{})
+	args := testArgs(t, "app", fmt.Sprintf(`
+- name: app
+  vault:
+    addr: %s
+    method: token
+    config:
+      role: app
+  providers:
+  - type: aws
+    name: deploy
+    method: creds
+    config:
+      role: deploy
+  - type: secret
+    config:
+      path: secret/app
+      secret_map:
+        password: PASSWORD
+`, server.URL))
+	creds := "[app]\nexport VAULT_TOKEN=\"expired\"\nexport VAULT_TTL=\"2020-01-01 00:00:00\"\n"
+	if err := os.WriteFile(args.CredentialsFile, []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args.DryRun = true
+	output, err := captureStdout(t, func() error { return load(args) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"renew (expired)", "read   aws/creds/deploy", "read   secret/app"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("expected %q in the plan, got\n%s", line, output)
+		}
+	}
+	if len(*requests) != 0 {
+		t.Errorf("expected no request to vault, got %v", *requests)
+	}
+	if content, _ := os.ReadFile(args.CredentialsFile); string(content) != creds {
+		t.Errorf("expected the credentials file unchanged, got\n%s", content)
+	}
+	if _, err = os.Stat(args.ExecutableFile); !os.IsNotExist(err) {
+		t.Errorf("expected no export file to be written - %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	awsDefaultBackend     = "aws"
+	awsAccessKeyVar       = "AWS_ACCESS_KEY_ID"
+	awsSecretKeyVar       = "AWS_SECRET_ACCESS_KEY"
+	awsSessionTokenVar    = "AWS_SESSION_TOKEN"
+	awsRegionVar          = "AWS_REGION"
+	awsExpirationVar      = "AWS_CREDENTIAL_EXPIRATION"
+	awsCredentialsFile    = ".aws/credentials"
+	awsProcessVersion     = 1
+	awsAccessKeyField     = "access_key"
+	awsSecretKeyField     = "secret_key"
+	awsSecurityTokenField = "security_token"
+)
+
+func init() {
+	Register("aws", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewAWSProvider(client, config)
+	})
+	RegisterSpec("aws", Spec{
+		Methods:  []string{"creds", "sts"},
+		Config:   AWSConfig{},
+		Keys:     map[string][]string{"": {"role", "region", "profile", "file"}, "sts": {"ttl", "role_arn"}},
+		Required: map[string][]string{"": {"config.role"}},
+	})
+}
+
+type AWSConfig struct {
+	Role    string `yaml:"role"`
+	RoleARN string `yaml:"role_arn"`
+	TTL     string `yaml:"ttl"`
+	Region  string `yaml:"region"`
+	Profile string `yaml:"profile"`
+	File    string `yaml:"file"`
+}
+
+// AWSProvider reads credentials from the AWS secrets engine, either for an
+// IAM user (method creds) or assuming a role (method sts).
+type AWSProvider struct {
+	client  *VaultClient
+	config  config.ProviderConfig
+	options AWSConfig
+	creds   *credSet
+}
+
+// awsProcessCreds is the output expected by the AWS SDKs from a
+// credential_process.
+type awsProcessCreds struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+func NewAWSProvider(client *VaultClient, config config.ProviderConfig) (*AWSProvider, error) {
+	p := &AWSProvider{client: client, config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	if p.config.Backend == "" {
+		p.config.Backend = awsDefaultBackend
+	}
+	if p.options.Profile != "" && p.options.File == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		p.options.File = filepath.Join(home, awsCredentialsFile)
+	}
+	return p, nil
+}
+
+func (p *AWSProvider) LoadProfileCreds(info []string) {
+	p.creds.load(info)
+}
+
+func (p *AWSProvider) GenerateCreds() (string, error) {
+	switch p.config.Method {
+	case "creds", "sts":
+	default:
+		return "", fmt.Errorf("method %s not implemented yet", p.config.Method)
+	}
+	path := fmt.Sprintf("%s/%s/%s", p.config.Backend, p.config.Method, p.options.Role)
+	data := map[string][]string{}
+	if p.options.TTL != "" {
+		data["ttl"] = []string{p.options.TTL}
+	}
+	if p.options.RoleARN != "" {
+		data["role_arn"] = []string{p.options.RoleARN}
+	}
+	secret, err := p.client.Logical().ReadWithData(path, data)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no credentials found in %s", path)
+	}
+	accessKey, _ := secret.Data[awsAccessKeyField].(string)
+	secretKey, _ := secret.Data[awsSecretKeyField].(string)
+	sessionToken, _ := secret.Data[awsSecurityTokenField].(string)
+	TTL, _ := time.ParseDuration(fmt.Sprintf("%ds", secret.LeaseDuration))
+
+	p.creds.reset()
+	p.creds.TTL = time.Now().Add(TTL)
+	p.creds.lease = secret.LeaseID
+	p.creds.set(awsAccessKeyVar, accessKey)
+	p.creds.set(awsSecretKeyVar, secretKey)
+	if sessionToken != "" {
+		p.creds.set(awsSessionTokenVar, sessionToken)
+	}
+	p.creds.set(awsExpirationVar, p.creds.TTL.UTC().Format(time.RFC3339))
+	if p.options.Region != "" {
+		p.creds.set(awsRegionVar, p.options.Region)
+	}
+	if p.options.Profile != "" {
+		if err = p.writeProfile(); err != nil {
+			return "", err
+		}
+	}
+	return accessKey, nil
+}
+
+// writeProfile replaces the named profile in the AWS credentials file with
+// the current credentials.
+func (p *AWSProvider) writeProfile() error {
+	section := fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\n", p.options.Profile,
+		p.creds.vars[awsAccessKeyVar], p.creds.vars[awsSecretKeyVar])
+	if token := p.creds.vars[awsSessionTokenVar]; token != "" {
+		section += fmt.Sprintf("aws_session_token = %s\n", token)
+	}
+	return p.updateProfile(section)
+}
+
+// updateProfile replaces the profile section of the credentials file with
+// section, removing it when it's empty.
+func (p *AWSProvider) updateProfile(section string) error {
+	content, err := os.ReadFile(p.options.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var result bytes.Buffer
+	header := fmt.Sprintf("[%s]", p.options.Profile)
+	skip := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") {
+			skip = trimmed == header
+		}
+		if !skip {
+			result.WriteString(line + "\n")
+		}
+	}
+	if section != "" {
+		if result.Len() > 0 && !bytes.HasSuffix(result.Bytes(), []byte("\n\n")) {
+			result.WriteString("\n")
+		}
+		result.WriteString(section)
+	}
+	if err = os.MkdirAll(filepath.Dir(p.options.File), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(p.options.File, result.Bytes(), 0600)
+}
+
+// CredentialProcess returns the credentials in the format of the
+// credential_process of the AWS SDKs.
+func (p *AWSProvider) CredentialProcess() ([]byte, error) {
+	if !p.CredsLoaded() {
+		return nil, fmt.Errorf("no credentials loaded for provider %s", p.config.ID())
+	}
+	return json.Marshal(awsProcessCreds{
+		Version:         awsProcessVersion,
+		AccessKeyId:     p.creds.vars[awsAccessKeyVar],
+		SecretAccessKey: p.creds.vars[awsSecretKeyVar],
+		SessionToken:    p.creds.vars[awsSessionTokenVar],
+		Expiration:      p.creds.vars[awsExpirationVar],
+	})
+}
+
+func (p *AWSProvider) VaultPaths() []VaultPath {
+	return []VaultPath{{Path: fmt.Sprintf("%s/%s/%s", p.config.Backend, p.config.Method, p.options.Role), Capability: "read"}}
+}
+
+// RemoveFiles removes the profile from the AWS credentials file.
+func (p *AWSProvider) RemoveFiles() error {
+	if p.options.Profile != "" {
+		return p.updateProfile("")
+	}
+	return nil
+}
+
+// RevokeCreds revokes the lease of the credentials.
+func (p *AWSProvider) RevokeCreds() error {
+	return p.creds.revoke(p.client)
+}
+
+func (p *AWSProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *AWSProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *AWSProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *AWSProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
@@ -0,0 +1,106 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+	"gopkg.in/yaml.v3"
+)
+
+// testVault starts a vault stand-in answering the paths of handlers, with a
+// value, a status code or a function of the request, and returns a client
+// with a token for it.
+func testVault(t *testing.T, handlers map[string]interface{}) *VaultClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := handlers[r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if handler, ok := response.(func(*http.Request) interface{}); ok {
+			response = handler(r)
+		}
+		if status, ok := response.(int); ok {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"errors":["test error"]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	client, err := NewVaultClient(config.VaultConfig{Addr: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("test-token")
+	return client
+}
+
+// testProvider returns the configuration of a provider with the yaml config.
+func testProvider(t *testing.T, providerType string, method string, content string) config.ProviderConfig {
+	t.Helper()
+	p := config.ProviderConfig{Type: providerType, Method: method, Profile: "test"}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Content) > 0 {
+		p.Config = *doc.Content[0]
+	}
+	return p
+}
+
+func TestAWSCredentialProcess(t *testing.T) {
+	client := testVault(t, map[string]interface{}{
+		"/v1/aws/sts/deploy": map[string]interface{}{
+			"lease_id":       "aws/sts/deploy/abc",
+			"lease_duration": 3600,
+			"data": map[string]interface{}{
+				"access_key":     "AKIATEST",
+				"secret_key":     "secret",
+				"security_token": "session",
+			},
+		},
+	})
+	provider, err := NewAWSProvider(client, testProvider(t, "aws", "sts", "role: deploy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.CredentialProcess(); err == nil {
+		t.Fatal("expected an error without credentials loaded")
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := provider.CredentialProcess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var creds awsProcessCreds
+	if err = json.Unmarshal(content, &creds); err != nil {
+		t.Fatalf("invalid credential_process output %s - %s", content, err)
+	}
+	if creds.Version != 1 || creds.AccessKeyId != "AKIATEST" || creds.SecretAccessKey != "secret" || creds.SessionToken != "session" {
+		t.Fatalf("unexpected credentials %+v", creds)
+	}
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil || time.Until(expiration) < 59*time.Minute {
+		t.Fatalf("unexpected expiration %s", creds.Expiration)
+	}
+
+	// The cached credentials are used by a new provider without vault.
+	cached, err := NewAWSProvider(nil, testProvider(t, "aws", "sts", "role: deploy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.LoadProfileCreds(provider.ProfileCreds())
+	if content2, err := cached.CredentialProcess(); err != nil || string(content2) != string(content) {
+		t.Fatalf("expected the cached credentials %s, got %s - %v", content, content2, err)
+	}
+}
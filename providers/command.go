@@ -0,0 +1,245 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	commandDefaultTimeout = 30 * time.Second
+)
+
+func init() {
+	Register("command", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewCommandProvider(client, config)
+	})
+	RegisterSpec("command", Spec{
+		Methods: []string{"dotenv", "json", "value"},
+		Config:  CommandConfig{},
+		Keys: map[string][]string{
+			"":      {"command", "env", "ttl", "timeout", "vault_token", "vault_role", "vault_policies"},
+			"json":  {"secret_map", "ttl_field"},
+			"value": {"var"},
+		},
+		Required: map[string][]string{"": {"config.command"}, "value": {"config.var"}},
+		Validate: func(_ config.ProviderConfig, options interface{}) []config.FieldError {
+			if o := options.(*CommandConfig); o.VaultToken && o.VaultRole == "" && len(o.VaultPolicies) == 0 {
+				return []config.FieldError{{Key: "config.vault_token", Msg: "config.vault_role or config.vault_policies is required with vault_token"}}
+			}
+			return nil
+		},
+	})
+}
+
+type CommandConfig struct {
+	Command       []string          `yaml:"command"`
+	Env           map[string]string `yaml:"env"`
+	Var           string            `yaml:"var"`
+	SecretMap     map[string]string `yaml:"secret_map"`
+	TTL           string            `yaml:"ttl"`
+	TTLField      string            `yaml:"ttl_field"`
+	Timeout       string            `yaml:"timeout"`
+	VaultToken    bool              `yaml:"vault_token"`
+	VaultRole     string            `yaml:"vault_role"`
+	VaultPolicies []string          `yaml:"vault_policies"`
+}
+
+// CommandProvider runs a command and parses its output, as dotenv lines, as a
+// JSON object or as a single value, into the variables to export.
+type CommandProvider struct {
+	client  *VaultClient
+	config  config.ProviderConfig
+	options CommandConfig
+	creds   *credSet
+}
+
+func NewCommandProvider(client *VaultClient, config config.ProviderConfig) (*CommandProvider, error) {
+	p := &CommandProvider{client: client, config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *CommandProvider) LoadProfileCreds(info []string) {
+	p.creds.load(info)
+}
+
+func (p *CommandProvider) run() (output []byte, err error) {
+	if len(p.options.Command) == 0 {
+		return nil, fmt.Errorf("command not configured")
+	}
+	timeout := commandDefaultTimeout
+	if p.options.Timeout != "" {
+		if timeout, err = time.ParseDuration(p.options.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout %s - %s", p.options.Timeout, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.options.Command[0], p.options.Command[1:]...)
+	cmd.Env = os.Environ()
+	for key, value := range p.options.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+	if p.options.VaultToken && p.client != nil && p.client.CredsLoaded() {
+		var token string
+		token, err = p.client.scopedToken(fmt.Sprintf("clusterprofile-command-%s", p.config.ID()), p.options.VaultRole, p.options.VaultPolicies)
+		if err != nil {
+			return nil, fmt.Errorf("error creating scoped vault token - %s", err)
+		}
+		// The token is revoked even when the command fails, reporting the
+		// revocation error only if there is no other one.
+		defer func() {
+			if revokeErr := p.client.revokeScopedToken(token); revokeErr != nil && err == nil {
+				output, err = nil, revokeErr
+			}
+		}()
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", vaultEnvAddrVar, p.client.Address()), fmt.Sprintf("%s=%s", vaultEnvTokenVar, token))
+	}
+	var outb bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &outb
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("command %s timed out after %s", p.options.Command[0], timeout)
+		}
+		return nil, fmt.Errorf("error running %s - %s", p.options.Command[0], err)
+	}
+	return outb.Bytes(), nil
+}
+
+func (p *CommandProvider) VaultPaths() []VaultPath {
+	if !p.options.VaultToken {
+		return nil
+	}
+	return scopedTokenPaths(p.options.VaultRole)
+}
+
+// Check checks that the command is installed.
+func (p *CommandProvider) Check() []Check {
+	if len(p.options.Command) == 0 {
+		return nil
+	}
+	_, err := exec.LookPath(p.options.Command[0])
+	return []Check{{Name: "command " + p.options.Command[0], Err: err, Hint: "install it or add its folder to the PATH"}}
+}
+
+func (p *CommandProvider) GenerateCreds() (string, error) {
+	output, err := p.run()
+	if err != nil {
+		return "", err
+	}
+	var ttl time.Time
+	vars := map[string]string{}
+	switch p.config.Method {
+	case "dotenv":
+		vars = parseDotenv(output)
+	case "json":
+		if vars, ttl, err = p.parseJSON(output); err != nil {
+			return "", err
+		}
+	case "value":
+		vars[p.options.Var] = strings.TrimSpace(string(output))
+	default:
+		return "", fmt.Errorf("method %s not implemented yet", p.config.Method)
+	}
+	if p.options.TTL != "" {
+		duration, err := time.ParseDuration(p.options.TTL)
+		if err != nil {
+			return "", fmt.Errorf("invalid ttl %s - %s", p.options.TTL, err)
+		}
+		ttl = time.Now().Add(duration)
+	}
+	p.creds.reset()
+	p.creds.setAll(vars)
+	p.creds.TTL = ttl
+	return "", nil
+}
+
+// parseJSON maps the fields of the output object to variables, all of them
+// when there's no secret_map, and reads the expiration from the TTL field.
+func (p *CommandProvider) parseJSON(output []byte) (vars map[string]string, ttl time.Time, err error) {
+	var data map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	if err = decoder.Decode(&data); err != nil {
+		return nil, ttl, fmt.Errorf("error parsing output of %s - %s", p.options.Command[0], err)
+	}
+	vars = map[string]string{}
+	for key, value := range data {
+		if key == p.options.TTLField {
+			continue
+		}
+		if p.options.SecretMap == nil {
+			vars[key] = stringify(value)
+		} else if envName, ok := p.options.SecretMap[key]; ok {
+			vars[envName] = stringify(value)
+		}
+	}
+	if p.options.TTLField != "" {
+		if ttl, err = parseExpiration(stringify(data[p.options.TTLField])); err != nil {
+			return nil, ttl, fmt.Errorf("invalid %s in output of %s - %s", p.options.TTLField, p.options.Command[0], err)
+		}
+	}
+	return
+}
+
+// parseExpiration parses a timestamp or a number of seconds from now.
+func parseExpiration(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Now().Add(time.Duration(seconds) * time.Second), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseDotenv(output []byte) map[string]string {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) > 1 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		vars[strings.TrimSpace(parts[0])] = value
+	}
+	return vars
+}
+
+func (p *CommandProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *CommandProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *CommandProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *CommandProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
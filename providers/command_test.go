@@ -0,0 +1,146 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandScopedToken(t *testing.T) {
+	var create map[string]interface{}
+	revoked := ""
+	client := testVault(t, map[string]interface{}{
+		"/v1/auth/token/create": func(r *http.Request) interface{} {
+			json.NewDecoder(r.Body).Decode(&create)
+			return map[string]interface{}{"auth": map[string]interface{}{"client_token": "scoped-token"}}
+		},
+		"/v1/auth/token/revoke-self": func(r *http.Request) interface{} {
+			revoked = r.Header.Get("X-Vault-Token")
+			return http.StatusNoContent
+		},
+	})
+	provider, err := NewCommandProvider(client, testProvider(t, "command", "dotenv", `
+command: ["sh", "-c", "echo SEEN_TOKEN=$VAULT_TOKEN"]
+vault_token: true
+vault_policies: ["deploy"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if provider.creds.vars["SEEN_TOKEN"] != "scoped-token" {
+		t.Fatalf("expected the command to get the scoped token, got %v", provider.creds.vars)
+	}
+	policies, _ := create["policies"].([]interface{})
+	if len(policies) != 1 || policies[0] != "deploy" || create["num_uses"] != float64(scopedTokenUses) {
+		t.Fatalf("expected a token with the deploy policy and %d uses, got %v", scopedTokenUses, create)
+	}
+	if revoked != "scoped-token" {
+		t.Fatalf("expected the scoped token to revoke itself, got %q", revoked)
+	}
+}
+
+func TestCommandScopedTokenRevokeError(t *testing.T) {
+	client := testVault(t, map[string]interface{}{
+		"/v1/auth/token/create/deploy-role": map[string]interface{}{"auth": map[string]interface{}{"client_token": "scoped-token"}},
+		"/v1/auth/token/revoke-self":        http.StatusBadRequest,
+	})
+	provider, err := NewCommandProvider(client, testProvider(t, "command", "value", `
+command: ["true"]
+var: OUTPUT
+vault_token: true
+vault_role: deploy-role
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err == nil || !strings.Contains(err.Error(), "error revoking scoped vault token") {
+		t.Fatalf("expected the revocation error to be reported, got %v", err)
+	}
+}
+
+func TestCommandProviderOutput(t *testing.T) {
+	expires := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name   string
+		method string
+		output string
+		config string
+		export string
+		ttl    time.Duration
+		err    string
+	}{
+		{name: "dotenv", method: "dotenv", output: "# comment\nA=1\nexport B=\"two words\"\nC='single'\ninvalid\n",
+			export: `A="1",B="two words",C="single"`},
+		{name: "dotenv with ttl", method: "dotenv", output: "A=1", config: "ttl: 1h",
+			export: `A="1"`, ttl: time.Hour},
+		{name: "json", method: "json", output: `{"user": "u", "port": 5432, "expires": 3600}`, config: "ttl_field: expires",
+			export: `port="5432",user="u"`, ttl: time.Hour},
+		{name: "json timestamp", method: "json", output: `{"user": "u", "expires": "` + expires + `"}`, config: "ttl_field: expires",
+			export: `user="u"`, ttl: 2 * time.Hour},
+		{name: "json secret_map", method: "json", output: `{"user": "u", "password": "p"}`, config: "secret_map: {user: DB_USER}",
+			export: `DB_USER="u"`},
+		{name: "json malformed", method: "json", output: `user=u`, err: "error parsing output of sh"},
+		{name: "json invalid ttl_field", method: "json", output: `{"user": "u", "expires": "soon"}`, config: "ttl_field: expires",
+			err: "invalid expires in output of sh"},
+		{name: "value", method: "value", output: "  token\n", config: "var: TOKEN", export: `TOKEN="token"`},
+		{name: "invalid ttl", method: "value", output: "token", config: "var: TOKEN\nttl: forever", err: "invalid ttl forever"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := fmt.Sprintf("command: [\"sh\", \"-c\", \"printf '%%s' \\\"$OUT\\\"\"]\nenv:\n  OUT: %q\n%s", test.output, test.config)
+			provider, err := NewCommandProvider(nil, testProvider(t, "command", test.method, config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = provider.GenerateCreds()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected the error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := exported(provider); got != test.export {
+				t.Fatalf("expected %s, got %s", test.export, got)
+			}
+			expires := provider.CacheState().Expires
+			if test.ttl == 0 && !expires.IsZero() {
+				t.Fatalf("expected no expiration, got %s", expires)
+			}
+			if test.ttl != 0 && time.Until(expires).Round(time.Minute) != test.ttl {
+				t.Fatalf("expected to expire in %s, got %s", test.ttl, expires)
+			}
+		})
+	}
+}
+
+func TestCommandProviderFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"timeout", "command: [\"sleep\", \"5\"]\ntimeout: 100ms", "command sleep timed out after 100ms"},
+		{"exit status", "command: [\"false\"]", "error running false - exit status 1"},
+		{"invalid timeout", "command: [\"true\"]\ntimeout: soon", `invalid timeout soon - time: invalid duration "soon"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, err := NewCommandProvider(nil, testProvider(t, "command", "dotenv", test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err == nil || err.Error() != test.err {
+				t.Fatalf("expected the error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
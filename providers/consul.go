@@ -2,28 +2,142 @@ package providers
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/smorenodp/clusterprofile/config"
 )
 
 const (
-	consulRoleTokenKey = "token"
-	consulEnvTokenVar  = "CONSUL_HTTP_TOKEN"
-	consulEnvTTLVar    = "CONSUL_TTL"
-	consulEnvAddrVar   = "CONSUL_HTTP_ADDR"
+	consulRoleTokenKey        = "token"
+	consulEnvTokenVar         = "CONSUL_HTTP_TOKEN"
+	consulEnvTTLVar           = "CONSUL_TTL"
+	consulEnvAddrVar          = "CONSUL_HTTP_ADDR"
+	consulEnvSSLVar           = "CONSUL_HTTP_SSL"
+	consulEnvCACertVar        = "CONSUL_CACERT"
+	consulEnvClientCertVar    = "CONSUL_CLIENT_CERT"
+	consulEnvClientKeyVar     = "CONSUL_CLIENT_KEY"
+	consulEnvTLSServerNameVar = "CONSUL_TLS_SERVER_NAME"
+	consulEnvNamespaceVar     = "CONSUL_NAMESPACE"
+	consulEnvPartitionVar     = "CONSUL_PARTITION"
+	// consulEnvDatacenterVar is not read by the consul CLI, it's only stored
+	// for consul_kv and the references of other providers.
+	consulEnvDatacenterVar = "CONSUL_DATACENTER"
+	consulBearerKey        = "token"
+	consulOIDCCallbackPort = 8550
+	// Tokens created by vault can take a while to be replicated, so the
+	// verification is retried during consulVerifyTimeout.
+	consulVerifyTimeout  = 15 * time.Second
+	consulVerifyInterval = time.Second
+	// consulTokenTTL is how long the tokens of the auth methods without an
+	// expiration time are reused.
+	consulTokenTTL = 8 * time.Hour
 )
 
+func init() {
+	Register("consul", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewConsulProvider(client, config)
+	})
+	RegisterSpec("consul", Spec{
+		Methods: []string{"role", "token", "login", "oidc"},
+		Config:  ConsulConfig{},
+		Keys: map[string][]string{"": {"ssl", "ca_cert", "client_cert", "client_key", "tls_server_name", "namespace", "partition", "datacenter", "verify"},
+			"role": {"role"}, "token": {"token"},
+			"login": {"auth_method", "bearer_file", "bearer_env", "bearer_path", "bearer_key"}, "oidc": {"auth_method", "callback_port"}},
+		Required: map[string][]string{"role": {"backend", "config.role"}, "token": {"config.token"},
+			"login": {"addr", "config.auth_method"}, "oidc": {"addr", "config.auth_method"}},
+		Validate: func(p config.ProviderConfig, options interface{}) []config.FieldError {
+			if options.(*ConsulConfig).Verify && p.Addr == "" {
+				return []config.FieldError{{Key: "addr", Msg: "addr is required with verify"}}
+			}
+			return nil
+		},
+	})
+}
+
+type ConsulConfig struct {
+	Role          string `yaml:"role"`
+	Token         string `yaml:"token"`
+	AuthMethod    string `yaml:"auth_method"`
+	BearerFile    string `yaml:"bearer_file"`
+	BearerEnv     string `yaml:"bearer_env"`
+	BearerPath    string `yaml:"bearer_path"`
+	BearerKey     string `yaml:"bearer_key"`
+	CallbackPort  int    `yaml:"callback_port"`
+	SSL           bool   `yaml:"ssl"`
+	CACert        string `yaml:"ca_cert"`
+	ClientCert    string `yaml:"client_cert"`
+	ClientKey     string `yaml:"client_key"`
+	TLSServerName string `yaml:"tls_server_name"`
+	Namespace     string `yaml:"namespace"`
+	Partition     string `yaml:"partition"`
+	Datacenter    string `yaml:"datacenter"`
+	Verify        bool   `yaml:"verify"`
+}
+
+// consulACLToken is the token returned by the login endpoints of Consul.
+type consulACLToken struct {
+	AccessorID     string
+	SecretID       string
+	ExpirationTime *time.Time
+}
+
 type ConsulProvider struct {
-	vault  *VaultClient
-	config config.ProviderConfig
-	token  string
-	TTL    time.Time
+	vault   *VaultClient
+	api     *http.Client
+	config  config.ProviderConfig
+	options ConsulConfig
+	token   string
+	TTL     time.Time
+	stored  bool
+}
+
+func NewConsulProvider(vault *VaultClient, config config.ProviderConfig) (*ConsulProvider, error) {
+	p := &ConsulProvider{vault: vault, config: config}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func NewConsulProvider(vault *VaultClient, config config.ProviderConfig) *ConsulProvider {
-	return &ConsulProvider{vault: vault, config: config}
+func (p *ConsulProvider) tls() tlsConfig {
+	return tlsConfig{CACert: p.options.CACert, ClientCert: p.options.ClientCert,
+		ClientKey: p.options.ClientKey, ServerName: p.options.TLSServerName}
+}
+
+// endpoint returns the URL of the API path in the datacenter, namespace and
+// partition configured, using https without scheme in the address when ssl
+// is enabled, like the consul CLI.
+func (p *ConsulProvider) endpoint(path string) string {
+	addr := p.config.Addr
+	if p.options.SSL && !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	endpoint := serviceURL(addr) + path
+	if query := consulQuery(p.options.Datacenter, p.options.Namespace, p.options.Partition); len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
+
+// consulQuery returns the parameters of the Consul API to select the
+// datacenter, the namespace and the partition.
+func consulQuery(datacenter string, namespace string, partition string) url.Values {
+	query := url.Values{}
+	if datacenter != "" {
+		query.Set("dc", datacenter)
+	}
+	if namespace != "" {
+		query.Set("ns", namespace)
+	}
+	if partition != "" {
+		query.Set("partition", partition)
+	}
+	return query
 }
 
 func (p *ConsulProvider) LoadProfileCreds(info []string) {
@@ -38,6 +152,7 @@ func (p *ConsulProvider) LoadProfileCreds(info []string) {
 			ttl, _ = time.Parse(layout, matches[1])
 		}
 	}
+	p.stored = token != ""
 	if time.Now().Before(ttl) {
 		p.token = token
 		p.TTL = ttl
@@ -46,7 +161,7 @@ func (p *ConsulProvider) LoadProfileCreds(info []string) {
 }
 
 func (p *ConsulProvider) credsFromRole() (string, error) {
-	path := fmt.Sprintf("%s/creds/%s", p.config.Backend, p.config.Config.Role)
+	path := fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role)
 	secret, err := p.vault.Logical().Read(path)
 	if err != nil {
 		return "", err
@@ -62,33 +177,200 @@ func (p *ConsulProvider) credsFromRole() (string, error) {
 }
 
 func (p *ConsulProvider) credsFromToken() (string, error) {
-	p.token = p.config.Config.Token
+	p.token = p.options.Token
 	return p.token, nil
 }
 
-func (p *ConsulProvider) GenerateCreds() (string, error) {
+// bearerToken returns the token to log in with the auth method, read from a
+// file, an environment variable or a secret in vault.
+func (p *ConsulProvider) bearerToken() (string, error) {
+	switch {
+	case p.options.BearerFile != "":
+		content, err := os.ReadFile(p.options.BearerFile)
+		return strings.TrimSpace(string(content)), err
+	case p.options.BearerEnv != "":
+		if value := os.Getenv(p.options.BearerEnv); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("environment variable %s is empty", p.options.BearerEnv)
+	case p.options.BearerPath != "":
+		key := p.options.BearerKey
+		if key == "" {
+			key = consulBearerKey
+		}
+		data, _, err := p.vault.readKV(p.vault.kvPath(p.options.BearerPath, "", 0), 0, false)
+		if err != nil {
+			return "", err
+		}
+		if value, ok := data[key]; ok {
+			return stringify(value), nil
+		}
+		return "", fmt.Errorf("key %s not found in %s", key, p.options.BearerPath)
+	}
+	return "", fmt.Errorf("bearer_file, bearer_env or bearer_path is required for method login")
+}
+
+// credsFromLogin logs in with an auth method of Consul, like the JWT or
+// kubernetes ones.
+func (p *ConsulProvider) credsFromLogin() (string, error) {
+	bearer, err := p.bearerToken()
+	if err != nil {
+		return "", err
+	}
+	var token consulACLToken
+	request := map[string]string{"AuthMethod": p.options.AuthMethod, "BearerToken": bearer}
+	if err = apiRequest(p.api, http.MethodPost, p.endpoint("/v1/acl/login"), nil, request, &token); err != nil {
+		return "", fmt.Errorf("error in consul login - %s", err)
+	}
+	return p.setACLToken(token), nil
+}
+
+// credsFromOIDC logs in with an OIDC auth method of Consul in the browser.
+func (p *ConsulProvider) credsFromOIDC() (string, error) {
+	port := p.options.CallbackPort
+	if port == 0 {
+		port = consulOIDCCallbackPort
+	}
+	result, err := oidcLogin(port, func(redirect string, nonce string) (string, error) {
+		var response struct{ AuthURL string }
+		request := map[string]string{"AuthMethod": p.options.AuthMethod, "RedirectURI": redirect, "ClientNonce": nonce}
+		err := apiRequest(p.api, http.MethodPost, p.endpoint("/v1/acl/oidc/auth-url"), nil, request, &response)
+		return response.AuthURL, err
+	})
+	if err != nil {
+		return "", err
+	}
+	var token consulACLToken
+	request := map[string]string{"AuthMethod": p.options.AuthMethod, "ClientNonce": result.Nonce,
+		"State": result.State, "Code": result.Code}
+	if err = apiRequest(p.api, http.MethodPost, p.endpoint("/v1/acl/oidc/callback"), nil, request, &token); err != nil {
+		return "", fmt.Errorf("error completing consul oidc login - %s", err)
+	}
+	return p.setACLToken(token), nil
+}
+
+func (p *ConsulProvider) setACLToken(token consulACLToken) string {
+	p.token = token.SecretID
+	p.TTL = time.Now().Add(consulTokenTTL)
+	if token.ExpirationTime != nil {
+		p.TTL = token.ExpirationTime.Local()
+	}
+	return p.token
+}
+
+// RemoveFiles does nothing, the tokens are only kept in the credentials file.
+func (p *ConsulProvider) RemoveFiles() error {
+	return nil
+}
+
+// RevokeCreds destroys the tokens created with a login in Consul, the rest
+// are managed by vault or configured.
+func (p *ConsulProvider) RevokeCreds() error {
+	if p.token == "" || (p.config.Method != "login" && p.config.Method != "oidc") {
+		return nil
+	}
+	api, err := p.tls().client()
+	if err != nil {
+		return err
+	}
+	return apiRequest(api, http.MethodPost, p.endpoint("/v1/acl/logout"),
+		map[string]string{consulTokenHeader: p.token}, nil, nil)
+}
+
+func (p *ConsulProvider) VaultPaths() []VaultPath {
+	switch {
+	case p.config.Method == "role":
+		return []VaultPath{{Path: fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role), Capability: "read"}}
+	case p.config.Method == "login" && p.options.BearerPath != "":
+		return []VaultPath{{Path: p.options.BearerPath, Capability: "read"}}
+	}
+	return nil
+}
+
+func (p *ConsulProvider) GenerateCreds() (token string, err error) {
+	if p.options.Verify && p.config.Addr == "" {
+		return "", fmt.Errorf("addr is required to verify the consul token")
+	}
+	if p.api, err = p.tls().client(); err != nil {
+		return "", err
+	}
 	switch p.config.Method {
 	case "role":
-		return p.credsFromRole()
+		token, err = p.credsFromRole()
 	case "token":
-		return p.credsFromToken()
+		token, err = p.credsFromToken()
+	case "login":
+		token, err = p.credsFromLogin()
+	case "oidc":
+		token, err = p.credsFromOIDC()
 	default:
 		return "", fmt.Errorf("method %s not implemented yet", p.config.Method)
 	}
+	if err == nil && p.options.Verify {
+		err = p.verify()
+	}
+	return
 }
 
-func (p *ConsulProvider) ExportCreds() []string {
-	return []string{fmt.Sprintf("export %s=%q", consulEnvTokenVar, p.token),
-		fmt.Sprintf("export %s=%q", consulEnvTTLVar, p.TTL.Format(layout)),
-		fmt.Sprintf("export %s=%q", consulEnvAddrVar, p.config.Addr)}
+// verify checks that Consul accepts the token, retrying while it's not
+// found because it hasn't been replicated yet, and discards it otherwise.
+func (p *ConsulProvider) verify() (err error) {
+	deadline := time.Now().Add(consulVerifyTimeout)
+	headers := map[string]string{consulTokenHeader: p.token}
+	for {
+		err = apiRequest(p.api, http.MethodGet, p.endpoint("/v1/acl/token/self"), headers, nil, nil)
+		apiErr, ok := err.(apiError)
+		if err == nil || !ok || apiErr.status != http.StatusForbidden || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(consulVerifyInterval)
+	}
+	if err != nil {
+		p.token, p.TTL = "", time.Time{}
+		return fmt.Errorf("error verifying consul token - %s", err)
+	}
+	return nil
+}
+
+// envVars returns the variables of the provider, the optional ones only when
+// they are configured.
+func (p *ConsulProvider) envVars() [][2]string {
+	vars := [][2]string{{consulEnvTokenVar, p.token}, {consulEnvTTLVar, p.TTL.Format(layout)}, {consulEnvAddrVar, p.config.Addr}}
+	if p.options.SSL {
+		vars = append(vars, [2]string{consulEnvSSLVar, "true"})
+	}
+	optional := [][2]string{{consulEnvCACertVar, p.options.CACert}, {consulEnvClientCertVar, p.options.ClientCert},
+		{consulEnvClientKeyVar, p.options.ClientKey}, {consulEnvTLSServerNameVar, p.options.TLSServerName},
+		{consulEnvNamespaceVar, p.options.Namespace}, {consulEnvPartitionVar, p.options.Partition},
+		{consulEnvDatacenterVar, p.options.Datacenter}}
+	for _, v := range optional {
+		if v[1] != "" {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+func (p *ConsulProvider) ExportCreds() (export []string) {
+	for _, v := range p.envVars() {
+		if v[0] != consulEnvDatacenterVar {
+			export = append(export, fmt.Sprintf("export %s=%q", v[0], v[1]))
+		}
+	}
+	return
 }
 
 func (p *ConsulProvider) CredsLoaded() bool {
 	return p.token != ""
 }
 
-func (p *ConsulProvider) ProfileCreds() []string {
-	return []string{fmt.Sprintf("%s=%q", consulEnvTokenVar, p.token),
-		fmt.Sprintf("%s=%q", consulEnvTTLVar, p.TTL.Format(layout)),
-		fmt.Sprintf("%s=%q", consulEnvAddrVar, p.config.Addr)}
+func (p *ConsulProvider) CacheState() CacheState {
+	return CacheState{Stored: p.stored, Expires: p.TTL}
+}
+
+func (p *ConsulProvider) ProfileCreds() (creds []string) {
+	for _, v := range p.envVars() {
+		creds = append(creds, fmt.Sprintf("%s=%q", v[0], v[1]))
+	}
+	return
 }
@@ -0,0 +1,134 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testService starts a consul or nomad stand-in answering the paths of
+// handlers with the function of the request.
+func testService(t *testing.T, handlers map[string]func(*http.Request) interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(handler(r))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// browser replaces the browser with a request to the url, the callback of
+// the oidc login.
+func browser(t *testing.T) {
+	t.Helper()
+	original := openBrowser
+	openBrowser = func(url string) {
+		go func() {
+			if response, err := http.Get(url); err == nil {
+				response.Body.Close()
+			}
+		}()
+	}
+	t.Cleanup(func() { openBrowser = original })
+}
+
+func TestConsulLoginAndLogout(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	logout := ""
+	server := testService(t, map[string]func(*http.Request) interface{}{
+		"/v1/acl/login": func(r *http.Request) interface{} {
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			if request["AuthMethod"] != "ci" || request["BearerToken"] != "jwt" {
+				t.Errorf("unexpected login request %v", request)
+			}
+			return map[string]interface{}{"SecretID": "consul-token", "ExpirationTime": expiration}
+		},
+		"/v1/acl/logout": func(r *http.Request) interface{} {
+			logout = r.Header.Get(consulTokenHeader)
+			return nil
+		},
+	})
+	t.Setenv("CI_JWT", "jwt")
+	config := testProvider(t, "consul", "login", "auth_method: ci\nbearer_env: CI_JWT")
+	config.Addr = server.URL
+	provider, err := NewConsulProvider(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(exported(provider), `CONSUL_HTTP_TOKEN="consul-token"`) || !provider.TTL.Equal(expiration) {
+		t.Fatalf("expected the login token until %s, got %s until %s", expiration, exported(provider), provider.TTL)
+	}
+
+	cached, _ := NewConsulProvider(nil, config)
+	cached.LoadProfileCreds(provider.ProfileCreds())
+	if err = cached.RevokeCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if logout != "consul-token" {
+		t.Fatalf("expected the token to be logged out, got %q", logout)
+	}
+}
+
+func TestConsulOIDC(t *testing.T) {
+	browser(t)
+	nonce := ""
+	server := testService(t, map[string]func(*http.Request) interface{}{
+		"/v1/acl/oidc/auth-url": func(r *http.Request) interface{} {
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			nonce = request["ClientNonce"]
+			if request["AuthMethod"] != "sso" || request["RedirectURI"] != "http://localhost:8550/oidc/callback" {
+				t.Errorf("unexpected auth-url request %v", request)
+			}
+			return map[string]string{"AuthURL": request["RedirectURI"] + "?code=test-code&state=test-state"}
+		},
+		"/v1/acl/oidc/callback": func(r *http.Request) interface{} {
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			if request["Code"] != "test-code" || request["State"] != "test-state" || request["ClientNonce"] != nonce {
+				t.Errorf("unexpected callback request %v", request)
+			}
+			return map[string]interface{}{"SecretID": "oidc-token"}
+		},
+	})
+	config := testProvider(t, "consul", "oidc", "auth_method: sso")
+	config.Addr = server.URL
+	provider, err := NewConsulProvider(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if provider.token != "oidc-token" || time.Until(provider.TTL).Round(time.Hour) != consulTokenTTL {
+		t.Fatalf("expected the oidc token for %s, got %s until %s", consulTokenTTL, provider.token, provider.TTL)
+	}
+}
+
+func TestConsulDatacenterNotExported(t *testing.T) {
+	provider, err := NewConsulProvider(nil, testProvider(t, "consul", "token", "token: static\ndatacenter: dc1\nnamespace: team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if export := exported(provider); strings.Contains(export, "CONSUL_DATACENTER") || !strings.Contains(export, `CONSUL_NAMESPACE="team"`) {
+		t.Fatalf("expected the namespace and not the datacenter to be exported, got %s", export)
+	}
+	if creds := strings.Join(provider.ProfileCreds(), ","); !strings.Contains(creds, `CONSUL_DATACENTER="dc1"`) {
+		t.Fatalf("expected the datacenter to be stored for consul_kv, got %s", creds)
+	}
+}
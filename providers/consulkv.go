@@ -0,0 +1,254 @@
+package providers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	consulTokenHeader     = "X-Consul-Token"
+	consulKVDefaultTTL    = 10 * time.Minute
+	consulKVFieldSplitter = "."
+)
+
+func init() {
+	Register("consul_kv", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewConsulKVProvider(client, config)
+	})
+	RegisterSpec("consul_kv", Spec{
+		Config:   ConsulKVConfig{},
+		Required: map[string][]string{"": {"config.keys|config.prefix"}},
+		Depends: func(config config.ProviderConfig) []string {
+			var options ConsulKVConfig
+			if config.Decode(&options) != nil {
+				return nil
+			}
+			return []string{consulKVProvider(options)}
+		},
+	})
+}
+
+// consulKVProvider returns the provider whose token is used when the address
+// or the token are not configured, consul by default.
+func consulKVProvider(options ConsulKVConfig) string {
+	if options.Provider == "" {
+		return "consul"
+	}
+	return options.Provider
+}
+
+type ConsulKVConfig struct {
+	Keys       []string          `yaml:"keys"`
+	Prefix     string            `yaml:"prefix"`
+	JSON       bool              `yaml:"json"`
+	SecretMap  map[string]string `yaml:"secret_map"`
+	VarPrefix  string            `yaml:"var_prefix"`
+	Datacenter string            `yaml:"datacenter"`
+	Partition  string            `yaml:"partition"`
+	Refresh    string            `yaml:"refresh"`
+	Token      string            `yaml:"token"`
+	Provider   string            `yaml:"provider"`
+}
+
+// consulKVPair is an entry of the response of the Consul KV API.
+type consulKVPair struct {
+	Key   string
+	Value string
+}
+
+// ConsulKVProvider exports keys of the Consul KV store, reading them again
+// after the refresh interval.
+type ConsulKVProvider struct {
+	config  config.ProviderConfig
+	options ConsulKVConfig
+	refresh time.Duration
+	outputs map[string]string
+	creds   *credSet
+}
+
+func NewConsulKVProvider(client *VaultClient, config config.ProviderConfig) (*ConsulKVProvider, error) {
+	p := &ConsulKVProvider{config: config, creds: newCredSet(config.ID()), refresh: consulKVDefaultTTL}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	if len(p.options.Keys) == 0 && p.options.Prefix == "" {
+		return nil, fmt.Errorf("keys or prefix is required")
+	}
+	p.options.Provider = consulKVProvider(p.options)
+	if p.options.Refresh != "" {
+		refresh, err := time.ParseDuration(p.options.Refresh)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refresh %s - %s", p.options.Refresh, err)
+		}
+		p.refresh = refresh
+	}
+	return p, nil
+}
+
+// SetOutputs keeps the variables of the consul provider, the token and
+// address used to read the keys.
+func (p *ConsulKVProvider) SetOutputs(outputs config.Outputs) {
+	p.outputs = outputs[p.options.Provider]
+}
+
+func (p *ConsulKVProvider) LoadProfileCreds(info []string) {
+	p.creds.load(info)
+}
+
+// connection returns the address and token of Consul, the configured ones
+// or the ones of the consul provider. Without token the anonymous one is
+// used.
+func (p *ConsulKVProvider) connection() (addr string, token string, err error) {
+	addr, token = p.config.Addr, p.options.Token
+	if addr == "" {
+		addr = p.outputs[consulEnvAddrVar]
+	}
+	if token == "" {
+		token = p.outputs[consulEnvTokenVar]
+	}
+	if addr == "" {
+		err = fmt.Errorf("no consul address, configure it or load the %s provider before", p.options.Provider)
+	}
+	if p.outputs[consulEnvSSLVar] == "true" && !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	return serviceURL(addr), token, err
+}
+
+// option returns the value configured or, without it, the variable of the
+// consul provider.
+func (p *ConsulKVProvider) option(value string, envVar string) string {
+	if value == "" {
+		return p.outputs[envVar]
+	}
+	return value
+}
+
+// read returns the pairs of the key, all the ones under it when recurse.
+func (p *ConsulKVProvider) read(client *http.Client, addr string, token string, key string, recurse bool) ([]consulKVPair, error) {
+	query := consulQuery(p.option(p.options.Datacenter, consulEnvDatacenterVar),
+		p.outputs[consulEnvNamespaceVar], p.option(p.options.Partition, consulEnvPartitionVar))
+	if recurse {
+		query.Set("recurse", "true")
+	}
+	path := fmt.Sprintf("%s/v1/kv/%s", addr, strings.TrimPrefix(key, "/"))
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	headers := map[string]string{}
+	if token != "" {
+		headers[consulTokenHeader] = token
+	}
+	pairs := []consulKVPair{}
+	if err := apiRequest(client, http.MethodGet, path, headers, nil, &pairs); err != nil {
+		return nil, fmt.Errorf("error reading consul key %s - %s", key, err)
+	}
+	return pairs, nil
+}
+
+func (p *ConsulKVProvider) GenerateCreds() (string, error) {
+	addr, token, err := p.connection()
+	if err != nil {
+		return "", err
+	}
+	// The TLS configuration is the one of the consul provider.
+	client, err := tlsConfig{CACert: p.outputs[consulEnvCACertVar], ClientCert: p.outputs[consulEnvClientCertVar],
+		ClientKey: p.outputs[consulEnvClientKeyVar], ServerName: p.outputs[consulEnvTLSServerNameVar]}.client()
+	if err != nil {
+		return "", err
+	}
+	items := map[string]string{}
+	for _, key := range p.options.Keys {
+		pairs, err := p.read(client, addr, token, key, false)
+		if err != nil {
+			return "", err
+		}
+		for _, pair := range pairs {
+			if err = p.addItem(items, pair.Key, pair.Value); err != nil {
+				return "", err
+			}
+		}
+	}
+	if p.options.Prefix != "" {
+		pairs, err := p.read(client, addr, token, p.options.Prefix, true)
+		if err != nil {
+			return "", err
+		}
+		prefix := strings.TrimSuffix(strings.TrimPrefix(p.options.Prefix, "/"), "/") + "/"
+		for _, pair := range pairs {
+			if strings.HasSuffix(pair.Key, "/") {
+				continue
+			}
+			if err = p.addItem(items, strings.TrimPrefix(pair.Key, prefix), pair.Value); err != nil {
+				return "", err
+			}
+		}
+	}
+	vars := map[string]string{}
+	for item, value := range items {
+		if envName := p.envName(item); envName != "" {
+			vars[envName] = value
+		}
+	}
+	p.creds.reset()
+	p.creds.setAll(vars)
+	p.creds.TTL = time.Now().Add(p.refresh)
+	return "", nil
+}
+
+// addItem adds the decoded value of a key, with json the fields of the
+// objects are added as <item>.<field>.
+func (p *ConsulKVProvider) addItem(items map[string]string, item string, encoded string) error {
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if p.options.JSON {
+		var value interface{}
+		if err = json.Unmarshal(content, &value); err != nil {
+			return fmt.Errorf("error decoding consul key %s - %s", item, err)
+		}
+		if fields, ok := value.(map[string]interface{}); ok {
+			for field, fieldValue := range fields {
+				items[item+consulKVFieldSplitter+field] = stringify(fieldValue)
+			}
+			return nil
+		}
+		items[item] = stringify(value)
+		return nil
+	}
+	items[item] = string(content)
+	return nil
+}
+
+// envName returns the variable of an item, the one in secret_map or, when
+// there is no mapping, the item in upper case.
+func (p *ConsulKVProvider) envName(item string) string {
+	if len(p.options.SecretMap) > 0 {
+		return p.options.SecretMap[item]
+	}
+	return p.options.VarPrefix + strings.ToUpper(regexp.MustCompile(markerRegex).ReplaceAllString(item, "_"))
+}
+
+func (p *ConsulKVProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *ConsulKVProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *ConsulKVProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *ConsulKVProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
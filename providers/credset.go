@@ -0,0 +1,158 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	markerPrefix = "CLUSTERPROFILE"
+	markerRegex  = "[^A-Za-z0-9]+"
+)
+
+// credSet is a set of variables generated together that expire at the same
+// time. Besides the variables it stores in the credentials file the list of
+// its keys, its TTL and the lease of the secret they come from, so providers
+// that don't know their variables in advance can recognize them.
+type credSet struct {
+	id     string
+	vars   map[string]string
+	keys   []string
+	TTL    time.Time
+	lease  string
+	stored bool
+}
+
+func newCredSet(id string) *credSet {
+	return &credSet{id: id, vars: make(map[string]string)}
+}
+
+func (c *credSet) marker(suffix string) string {
+	return markerName(c.id, suffix)
+}
+
+// markerName returns the name of the variable storing information about the
+// variables of the provider with the id in the credentials file.
+func markerName(id string, suffix string) string {
+	id = regexp.MustCompile(markerRegex).ReplaceAllString(id, "_")
+	return fmt.Sprintf("%s_%s_%s", markerPrefix, strings.ToUpper(id), suffix)
+}
+
+func (c *credSet) set(key string, value string) {
+	if _, ok := c.vars[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.vars[key] = value
+}
+
+func (c *credSet) setAll(vars map[string]string) {
+	keys := []string{}
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c.set(key, vars[key])
+	}
+}
+
+func (c *credSet) reset() {
+	c.vars = make(map[string]string)
+	c.keys = nil
+	c.TTL = time.Time{}
+	c.lease = ""
+}
+
+// load restores the set from the credential lines when all its variables are
+// present and it hasn't expired.
+func (c *credSet) load(info []string) bool {
+	values := parseCreds(info)
+	c.stored = values[c.marker("KEYS")] != ""
+	ttl, err := time.Parse(layout, values[c.marker("TTL")])
+	if err != nil || !time.Now().Before(ttl) || values[c.marker("KEYS")] == "" {
+		return false
+	}
+	// The variables are read from the lines before the KEYS marker, so the
+	// ones with the same name in other sets are not mixed up.
+	vars := values
+	for i, line := range info {
+		if strings.HasPrefix(line, c.marker("KEYS")+"=") {
+			vars = parseCreds(info[:i])
+		}
+	}
+	keys := strings.Split(values[c.marker("KEYS")], ",")
+	for _, key := range keys {
+		if _, ok := vars[key]; !ok {
+			return false
+		}
+	}
+	c.reset()
+	for _, key := range keys {
+		c.set(key, vars[key])
+	}
+	c.TTL = ttl
+	c.lease = values[c.marker("LEASE")]
+	return true
+}
+
+// revoke revokes the lease of the set, if any. Without vault client, because
+// the token of the profile expired, the lease is left to expire.
+func (c *credSet) revoke(client *VaultClient) error {
+	if c.lease == "" {
+		return nil
+	}
+	if client == nil {
+		return fmt.Errorf("vault token expired, lease %s not revoked", c.lease)
+	}
+	return client.Sys().Revoke(c.lease)
+}
+
+func (c *credSet) state() CacheState {
+	return CacheState{Stored: c.stored, Expires: c.TTL}
+}
+
+func (c *credSet) loaded() bool {
+	return len(c.keys) > 0
+}
+
+func (c *credSet) export() (export []string) {
+	for _, key := range c.keys {
+		export = append(export, fmt.Sprintf("export %s=%q", key, c.vars[key]))
+	}
+	return
+}
+
+func (c *credSet) profile() (creds []string) {
+	for _, key := range c.keys {
+		creds = append(creds, fmt.Sprintf("%s=%q", key, c.vars[key]))
+	}
+	if len(c.keys) > 0 {
+		creds = append(creds, fmt.Sprintf("%s=%q", c.marker("KEYS"), strings.Join(c.keys, ",")),
+			fmt.Sprintf("%s=%q", c.marker("TTL"), c.TTL.Format(layout)))
+		if c.lease != "" {
+			creds = append(creds, fmt.Sprintf("%s=%q", c.marker("LEASE"), c.lease))
+		}
+	}
+	return
+}
+
+// parseCreds returns the variables of the credential lines.
+func parseCreds(info []string) map[string]string {
+	values := map[string]string{}
+	for _, line := range info {
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			value = parts[1]
+		}
+		values[parts[0]] = value
+	}
+	return values
+}
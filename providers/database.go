@@ -0,0 +1,201 @@
+package providers
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	databaseDefaultBackend = "database"
+	databaseUserKey        = "username"
+	databasePasswordKey    = "password"
+)
+
+// databaseEngine holds the defaults of each database client.
+type databaseEngine struct {
+	userVar     string
+	passwordVar string
+	port        int
+}
+
+var databaseEngines = map[string]databaseEngine{
+	"postgres": {userVar: "PGUSER", passwordVar: "PGPASSWORD", port: 5432},
+	"mysql":    {userVar: "MYSQL_USER", passwordVar: "MYSQL_PWD", port: 3306},
+}
+
+func init() {
+	Register("database", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewDatabaseProvider(client, config)
+	})
+	RegisterSpec("database", Spec{
+		Config:   DatabaseConfig{},
+		Required: map[string][]string{"": {"config.role"}},
+	})
+}
+
+type DatabaseConfig struct {
+	Role        string `yaml:"role"`
+	Engine      string `yaml:"engine"`
+	UserVar     string `yaml:"user_var"`
+	PasswordVar string `yaml:"password_var"`
+	Host        string `yaml:"host"`
+	Port        int    `yaml:"port"`
+	Database    string `yaml:"database"`
+	DSNVar      string `yaml:"dsn_var"`
+	File        string `yaml:"file"`
+}
+
+// DatabaseProvider reads dynamic credentials from the database secrets engine,
+// keeping the lease to revoke it when the profile is removed.
+type DatabaseProvider struct {
+	client  *VaultClient
+	config  config.ProviderConfig
+	options DatabaseConfig
+	creds   *credSet
+}
+
+func NewDatabaseProvider(client *VaultClient, config config.ProviderConfig) (*DatabaseProvider, error) {
+	p := &DatabaseProvider{client: client, config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	if p.options.Engine == "" {
+		p.options.Engine = "postgres"
+	}
+	engine, ok := databaseEngines[p.options.Engine]
+	if !ok {
+		return nil, fmt.Errorf("database engine %s not implemented", p.options.Engine)
+	}
+	if p.options.UserVar == "" {
+		p.options.UserVar = engine.userVar
+	}
+	if p.options.PasswordVar == "" {
+		p.options.PasswordVar = engine.passwordVar
+	}
+	if p.options.Port == 0 {
+		p.options.Port = engine.port
+	}
+	if p.config.Backend == "" {
+		p.config.Backend = databaseDefaultBackend
+	}
+	return p, nil
+}
+
+func (p *DatabaseProvider) LoadProfileCreds(info []string) {
+	// The credentials are generated again when the file written with them
+	// was deleted.
+	if p.creds.load(info) && p.options.File != "" {
+		if _, err := os.Stat(p.options.File); err != nil {
+			p.creds.reset()
+		}
+	}
+}
+
+func (p *DatabaseProvider) GenerateCreds() (string, error) {
+	path := fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role)
+	secret, err := p.client.Logical().Read(path)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no credentials found in %s", path)
+	}
+	user, _ := secret.Data[databaseUserKey].(string)
+	password, _ := secret.Data[databasePasswordKey].(string)
+	TTL, _ := time.ParseDuration(fmt.Sprintf("%ds", secret.LeaseDuration))
+
+	p.creds.reset()
+	p.creds.set(p.options.UserVar, user)
+	p.creds.set(p.options.PasswordVar, password)
+	if p.options.DSNVar != "" {
+		p.creds.set(p.options.DSNVar, p.dsn(user, password))
+	}
+	if p.options.File != "" {
+		if err = p.writeFile(user, password); err != nil {
+			return "", err
+		}
+		if p.options.Engine == "postgres" {
+			p.creds.set("PGPASSFILE", p.options.File)
+		}
+	}
+	p.creds.TTL = time.Now().Add(TTL)
+	p.creds.lease = secret.LeaseID
+	return user, nil
+}
+
+// dsn returns the connection string of the database with the credentials.
+func (p *DatabaseProvider) dsn(user string, password string) string {
+	address := fmt.Sprintf("%s:%d", p.options.Host, p.options.Port)
+	if p.options.Engine == "mysql" {
+		return fmt.Sprintf("%s:%s@tcp(%s)/%s", user, password, address, p.options.Database)
+	}
+	dsn := url.URL{Scheme: "postgres", User: url.UserPassword(user, password), Host: address, Path: "/" + p.options.Database}
+	return dsn.String()
+}
+
+// writeFile writes the credentials in a .pgpass file, or the client section of
+// a my.cnf file for mysql.
+func (p *DatabaseProvider) writeFile(user string, password string) error {
+	var content string
+	if p.options.Engine == "mysql" {
+		content = fmt.Sprintf("[client]\nuser=%s\npassword=%s\n", user, password)
+		if p.options.Host != "" {
+			content += fmt.Sprintf("host=%s\nport=%d\n", p.options.Host, p.options.Port)
+		}
+	} else {
+		escape := strings.NewReplacer(`\`, `\\`, ":", `\:`)
+		host, database := p.options.Host, p.options.Database
+		if host == "" {
+			host = "*"
+		}
+		if database == "" {
+			database = "*"
+		}
+		content = fmt.Sprintf("%s:%d:%s:%s:%s\n", escape.Replace(host), p.options.Port, escape.Replace(database),
+			escape.Replace(user), escape.Replace(password))
+	}
+	if err := os.WriteFile(p.options.File, []byte(content), 0600); err != nil {
+		return fmt.Errorf("error writing %s - %s", p.options.File, err)
+	}
+	return os.Chmod(p.options.File, 0600)
+}
+
+func (p *DatabaseProvider) VaultPaths() []VaultPath {
+	return []VaultPath{{Path: fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role), Capability: "read"}}
+}
+
+// RemoveFiles deletes the file written with the credentials.
+func (p *DatabaseProvider) RemoveFiles() error {
+	if p.options.File != "" {
+		if err := os.Remove(p.options.File); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeCreds revokes the lease of the credentials.
+func (p *DatabaseProvider) RevokeCreds() error {
+	return p.creds.revoke(p.client)
+}
+
+func (p *DatabaseProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *DatabaseProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *DatabaseProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *DatabaseProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
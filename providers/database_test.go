@@ -0,0 +1,125 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// databaseVault answers the database role app with the user and password,
+// and records the leases revoked.
+func databaseVault(t *testing.T, password string, revoked *[]string) *VaultClient {
+	return testVault(t, map[string]interface{}{
+		"/v1/database/creds/app": map[string]interface{}{
+			"lease_id":       "database/creds/app/abc",
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"username": "v-app", "password": password},
+		},
+		"/v1/sys/leases/revoke": func(r *http.Request) interface{} {
+			var request struct {
+				LeaseID string `json:"lease_id"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			*revoked = append(*revoked, request.LeaseID)
+			return map[string]interface{}{}
+		},
+	})
+}
+
+func TestDatabaseProviderRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		password string
+		export   string
+		file     string
+	}{
+		{"postgres", "role: app\nhost: db\ndatabase: app\ndsn_var: DATABASE_URL", "p@ss",
+			`DATABASE_URL="postgres://v-app:p%40ss@db:5432/app",PGPASSWORD="p@ss",PGUSER="v-app"`, ""},
+		{"pgpass", "role: app\nhost: db\nfile: %s", `p:a\ss`,
+			`PGPASSFILE="%s",PGPASSWORD="p:a\\ss",PGUSER="v-app"`, "db:5432:*:v-app:p\\:a\\\\ss\n"},
+		{"mysql", "role: app\nengine: mysql\nhost: db\nport: 3307\ndatabase: app\ndsn_var: DSN\nfile: %s", "pass",
+			`DSN="v-app:pass@tcp(db:3307)/app",MYSQL_PWD="pass",MYSQL_USER="v-app"`, "[client]\nuser=v-app\npassword=pass\nhost=db\nport=3307\n"},
+		{"custom variables", "role: app\nuser_var: DB_USER\npassword_var: DB_PASSWORD", "pass",
+			`DB_PASSWORD="pass",DB_USER="v-app"`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "credentials")
+			content, export := test.config, test.export
+			if strings.Contains(content, "%s") {
+				content = fmt.Sprintf(content, file)
+			}
+			if strings.Contains(export, "%s") {
+				export = fmt.Sprintf(export, file)
+			}
+			provider, err := NewDatabaseProvider(databaseVault(t, test.password, &[]string{}), testProvider(t, "database", "", content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err != nil {
+				t.Fatal(err)
+			}
+			if got := exported(provider); got != export {
+				t.Errorf("expected %s, got %s", export, got)
+			}
+			if test.file == "" {
+				return
+			}
+			written, err := os.ReadFile(file)
+			if err != nil || string(written) != test.file {
+				t.Errorf("expected the file %q, got %q - %v", test.file, written, err)
+			}
+			if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("expected the file with mode 0600 - %v", err)
+			}
+		})
+	}
+}
+
+func TestDatabaseProviderCacheAndRemove(t *testing.T) {
+	revoked := []string{}
+	client := databaseVault(t, "pass", &revoked)
+	file := filepath.Join(t.TempDir(), ".pgpass")
+	config := testProvider(t, "database", "", fmt.Sprintf("role: app\nfile: %s", file))
+	provider, err := NewDatabaseProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	creds := provider.ProfileCreds()
+
+	cached, err := NewDatabaseProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.LoadProfileCreds(creds)
+	if !cached.CredsLoaded() || exported(cached) != exported(provider) {
+		t.Fatalf("expected the cached credentials, got %s", exported(cached))
+	}
+
+	if err = cached.RevokeCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if err = cached.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(revoked, ",") != "database/creds/app/abc" {
+		t.Fatalf("expected the lease to be revoked, got %v", revoked)
+	}
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed - %v", err)
+	}
+
+	// Without the file the cached credentials are not reused.
+	cached, _ = NewDatabaseProvider(client, config)
+	if cached.LoadProfileCreds(creds); cached.CredsLoaded() {
+		t.Fatal("expected the credentials to be generated again without the file")
+	}
+}
@@ -0,0 +1,141 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	pluginTimeout = 5 * time.Minute
+)
+
+type pluginVault struct {
+	Addr      string `json:"addr"`
+	Namespace string `json:"namespace,omitempty"`
+	Token     string `json:"token,omitempty"`
+}
+
+// pluginRequest is written as JSON to the stdin of the plugin.
+type pluginRequest struct {
+	Name    string                 `json:"name"`
+	Type    string                 `json:"type"`
+	Method  string                 `json:"method,omitempty"`
+	Backend string                 `json:"backend,omitempty"`
+	Addr    string                 `json:"addr,omitempty"`
+	Config  map[string]interface{} `json:"config"`
+	Vault   pluginVault            `json:"vault"`
+}
+
+// pluginToken is the configuration of the vault token handed to the plugin,
+// read from the config of the provider and not passed to it.
+type pluginToken struct {
+	Role     string   `yaml:"vault_role"`
+	Policies []string `yaml:"vault_policies"`
+}
+
+// pluginResponse is read as JSON from the stdout of the plugin.
+type pluginResponse struct {
+	Env       map[string]string `json:"env"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// ExecProvider runs an external clusterprofile-provider-<type> binary to
+// generate the credentials.
+type ExecProvider struct {
+	client *VaultClient
+	config config.ProviderConfig
+	binary string
+	token  pluginToken
+	creds  *credSet
+}
+
+func NewExecProvider(client *VaultClient, config config.ProviderConfig, binary string) (*ExecProvider, error) {
+	p := &ExecProvider{client: client, config: config, binary: binary, creds: newCredSet(config.ID())}
+	if config.Config.Kind != 0 {
+		if err := config.Config.Decode(&p.token); err != nil {
+			return nil, fmt.Errorf("error decoding the vault token of plugin %s - %s", config.Type, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *ExecProvider) LoadProfileCreds(info []string) {
+	p.creds.load(info)
+}
+
+// VaultPaths returns the path of the scoped token handed to the plugin.
+func (p *ExecProvider) VaultPaths() []VaultPath {
+	if p.token.Role == "" && len(p.token.Policies) == 0 {
+		return nil
+	}
+	return scopedTokenPaths(p.token.Role)
+}
+
+func (p *ExecProvider) GenerateCreds() (_ string, err error) {
+	request := pluginRequest{Name: p.config.ID(), Type: p.config.Type, Method: p.config.Method,
+		Backend: p.config.Backend, Addr: p.config.Addr, Config: map[string]interface{}{}}
+	if err = p.config.Decode(&request.Config); err != nil {
+		return "", err
+	}
+	delete(request.Config, "vault_role")
+	delete(request.Config, "vault_policies")
+	if p.VaultPaths() != nil && p.client != nil && p.client.CredsLoaded() {
+		var token string
+		if token, err = p.client.scopedToken(pluginPrefix+p.config.Type, p.token.Role, p.token.Policies); err != nil {
+			return "", fmt.Errorf("error creating scoped vault token - %s", err)
+		}
+		defer func() {
+			if revokeErr := p.client.revokeScopedToken(token); revokeErr != nil && err == nil {
+				err = revokeErr
+			}
+		}()
+		request.Vault = pluginVault{Addr: p.client.Address(), Namespace: p.client.config.Namespace, Token: token}
+	}
+	input, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.binary)
+	var outb bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &outb
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return "", fmt.Errorf("error running plugin %s - %s", p.binary, err)
+	}
+
+	var response pluginResponse
+	if err = json.Unmarshal(outb.Bytes(), &response); err != nil {
+		return "", fmt.Errorf("error parsing plugin %s output - %s", p.binary, err)
+	}
+	p.creds.reset()
+	p.creds.setAll(response.Env)
+	p.creds.TTL = response.ExpiresAt
+	return "", nil
+}
+
+func (p *ExecProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *ExecProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *ExecProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *ExecProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPlugin writes a plugin printing the output.
+func testPlugin(t *testing.T, output string) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), pluginPrefix+"test")
+	script := "#!/bin/sh\ncat > /dev/null\ncat <<'EOF'\n" + output + "\nEOF\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func TestExecProviderInvalidToken(t *testing.T) {
+	config := testProvider(t, "test", "", "vault_policies: {deploy: true}")
+	if _, err := NewExecProvider(nil, config, "unused"); err == nil {
+		t.Fatal("expected an error with invalid vault_policies")
+	}
+}
+
+func TestExecProviderExpiration(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name   string
+		output string
+		reused bool
+	}{
+		{"expires_at", `{"env": {"TOOL_TOKEN": "abc"}, "expires_at": "` + expires + `"}`, true},
+		{"no expires_at", `{"env": {"TOOL_TOKEN": "abc"}}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testProvider(t, "test", "", "role: dev")
+			provider, err := NewExecProvider(nil, config, testPlugin(t, test.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err != nil {
+				t.Fatal(err)
+			}
+			if got := exported(provider); got != `TOOL_TOKEN="abc"` {
+				t.Fatalf("expected the plugin variables, got %s", got)
+			}
+			cached, _ := NewExecProvider(nil, config, "unused")
+			if cached.LoadProfileCreds(provider.ProfileCreds()); cached.CredsLoaded() != test.reused {
+				t.Fatalf("expected reused %t, got %t", test.reused, cached.CredsLoaded())
+			}
+		})
+	}
+}
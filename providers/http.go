@@ -0,0 +1,116 @@
+package providers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	apiTimeout = 30 * time.Second
+)
+
+// apiError is returned when the API of a service answers with an error
+// status.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("status %d - %s", e.status, e.msg)
+}
+
+// apiRequest calls the HTTP API of a service, encoding body and decoding the
+// response into out when they are not nil.
+func apiRequest(client *http.Client, method string, url string, headers map[string]string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: apiTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return apiError{status: resp.StatusCode, msg: strings.TrimSpace(string(content))}
+	}
+	if out == nil || len(content) == 0 {
+		return nil
+	}
+	return json.Unmarshal(content, out)
+}
+
+// serviceURL returns the address of a service with its scheme, the addresses
+// of Consul are usually configured without it.
+func serviceURL(addr string) string {
+	if addr != "" && !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/")
+}
+
+// tlsConfig is the TLS configuration of the API of a service, with the paths
+// of the files in the format of the environment variables of its CLI.
+type tlsConfig struct {
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+}
+
+// client returns a client for the API of the service verifying it with the
+// CA and authenticating with the client certificate, or nil for the default
+// one when there is no TLS configuration.
+func (c tlsConfig) client() (*http.Client, error) {
+	if c == (tlsConfig{}) {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: c.ServerName}
+	if c.CACert != "" {
+		content, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+	}
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate %s - %s", c.ClientCert, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Timeout: apiTimeout, Transport: transport}, nil
+}
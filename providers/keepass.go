@@ -9,43 +9,90 @@ import (
 	"github.com/tobischo/gokeepasslib/v3"
 )
 
+func init() {
+	Register("keepass", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewKeePassProvider(client, config)
+	})
+	RegisterSpec("keepass", Spec{
+		Config:   KeepassConfig{},
+		Required: map[string][]string{"": {"config.file", "config.secret_map"}},
+	})
+}
+
+type KeepassConfig struct {
+	File      string            `yaml:"file"`
+	Group     string            `yaml:"group"`
+	Password  string            `yaml:"password"`
+	SecretMap map[string]string `yaml:"secret_map"`
+}
+
 type KeepassProvider struct {
-	vault  *VaultClient
-	config config.ProviderConfig
-	data   map[string]string
-	db     *gokeepasslib.Database
+	vault   *VaultClient
+	config  config.ProviderConfig
+	options KeepassConfig
+	data    map[string]string
+	db      *gokeepasslib.Database
 }
 
-func NewKeePassProvider(vault *VaultClient, config config.ProviderConfig) *KeepassProvider {
+func NewKeePassProvider(vault *VaultClient, config config.ProviderConfig) (*KeepassProvider, error) {
+	var options KeepassConfig
+	if err := config.Decode(&options); err != nil {
+		return nil, err
+	}
+	provider := &KeepassProvider{vault: vault, config: config, options: options, data: make(map[string]string)}
+	return provider, nil
+}
+
+// open decodes the database, asking for its password when it's not in an
+// environment variable. It's only opened to generate the credentials.
+func (k *KeepassProvider) open() error {
 	var password string
-	file, err := os.Open(config.Config.File)
+	file, err := os.Open(k.options.File)
 	if err != nil {
-		return nil
+		return err
 	}
+	defer file.Close()
 	db := gokeepasslib.NewDatabase()
-	if config.Config.Password != "" {
-		password = os.Getenv(config.Config.Password)
+	if k.options.Password != "" {
+		password = os.Getenv(k.options.Password)
 	} else {
-		fmt.Print("Enter password for Keepass > ")
+		fmt.Fprint(os.Stderr, "Enter password for Keepass > ")
 		fmt.Scanln(&password)
 	}
 	db.Credentials = gokeepasslib.NewPasswordCredentials(password)
 	err = gokeepasslib.NewDecoder(file).Decode(db)
 	db.UnlockProtectedEntries()
 	if err != nil {
-		return nil
+		return err
 	}
-	provider := &KeepassProvider{vault: vault, config: config, data: make(map[string]string), db: db}
-	return provider
+	k.db = db
+	return nil
+}
+
+// Check checks that the database can be read and its password is set.
+func (k *KeepassProvider) Check() []Check {
+	file, err := os.Open(k.options.File)
+	if err == nil {
+		file.Close()
+	}
+	checks := []Check{{Name: "keepass file " + k.options.File, Err: err, Hint: "check the file path and its permissions"}}
+	if k.options.Password != "" {
+		check := Check{Name: "keepass password", Hint: fmt.Sprintf("export the password in %s", k.options.Password)}
+		if os.Getenv(k.options.Password) == "" {
+			check.Err = fmt.Errorf("environment variable %s is empty", k.options.Password)
+		}
+		checks = append(checks, check)
+	}
+	return checks
 }
 
 func (k *KeepassProvider) getData() {
 	groups := k.db.Content.Root.Groups
 	var group *gokeepasslib.Group
-	if k.config.Config.Group == "" {
+	if k.options.Group == "" {
 		group = &groups[0]
 	} else {
-		group = getGroup(groups[0].Groups, k.config.Config.Group)
+		group = getGroup(groups[0].Groups, k.options.Group)
 	}
 	if group == nil {
 		return
@@ -72,13 +119,16 @@ func getGroup(groups []gokeepasslib.Group, name string) (result *gokeepasslib.Gr
 }
 
 func (k *KeepassProvider) GenerateCreds() (string, error) {
+	if err := k.open(); err != nil {
+		return "", err
+	}
 	k.getData()
 	return "", nil
 }
 
 func (k *KeepassProvider) ExportCreds() []string {
 	result := []string{}
-	for dbKey, osEnv := range k.config.Config.SecretMap {
+	for dbKey, osEnv := range k.options.SecretMap {
 		if value, ok := k.data[dbKey]; ok {
 			result = append(result, fmt.Sprintf("export %s=%s", osEnv, value))
 		}
@@ -88,8 +138,8 @@ func (k *KeepassProvider) ExportCreds() []string {
 
 func (k *KeepassProvider) LoadProfileCreds(info []string) {
 
-	values := make([]string, 0, len(k.config.Config.SecretMap))
-	for _, v2 := range k.config.Config.SecretMap {
+	values := make([]string, 0, len(k.options.SecretMap))
+	for _, v2 := range k.options.SecretMap {
 		values = append(values, v2)
 	}
 
@@ -103,7 +153,7 @@ func (k *KeepassProvider) LoadProfileCreds(info []string) {
 
 func (k *KeepassProvider) ProfileCreds() []string {
 	result := []string{}
-	for dbKey, osEnv := range k.config.Config.SecretMap {
+	for dbKey, osEnv := range k.options.SecretMap {
 		if value, ok := k.data[dbKey]; ok {
 			result = append(result, fmt.Sprintf("%s=%s", osEnv, value))
 		}
@@ -111,8 +161,14 @@ func (k *KeepassProvider) ProfileCreds() []string {
 	return result
 }
 
+// CacheState reports the variables of the database as stored when all of
+// them were loaded, they don't expire.
+func (k *KeepassProvider) CacheState() CacheState {
+	return CacheState{Stored: k.CredsLoaded()}
+}
+
 func (k *KeepassProvider) CredsLoaded() bool {
-	for os, _ := range k.config.Config.SecretMap {
+	for os, _ := range k.options.SecretMap {
 		if _, ok := k.data[os]; !ok {
 			return false
 		}
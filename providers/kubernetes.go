@@ -0,0 +1,268 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	kubernetesDefaultBackend = "kubernetes"
+	kubernetesTokenKey       = "service_account_token"
+	kubernetesEnvConfigVar   = "KUBECONFIG"
+	kubernetesConfigFolder   = ".clusterid/kube"
+)
+
+func init() {
+	Register("kubernetes", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewKubernetesProvider(client, config)
+	})
+	RegisterSpec("kubernetes", Spec{
+		Config:   KubernetesConfig{},
+		Required: map[string][]string{"": {"addr", "config.role", "config.namespace"}},
+	})
+}
+
+type KubernetesConfig struct {
+	Role               string `yaml:"role"`
+	Namespace          string `yaml:"namespace"`
+	TTL                string `yaml:"ttl"`
+	ClusterRoleBinding bool   `yaml:"cluster_role_binding"`
+	CACert             string `yaml:"ca_cert"`
+	Insecure           bool   `yaml:"insecure"`
+	Context            string `yaml:"context"`
+	File               string `yaml:"file"`
+}
+
+// kubeConfig is the subset of the kubeconfig file managed by the provider,
+// the entries are kept as nodes to preserve the ones written by other tools.
+type kubeConfig struct {
+	APIVersion     string                 `yaml:"apiVersion"`
+	Kind           string                 `yaml:"kind"`
+	Clusters       []kubeEntry            `yaml:"clusters"`
+	Users          []kubeEntry            `yaml:"users"`
+	Contexts       []kubeEntry            `yaml:"contexts"`
+	CurrentContext string                 `yaml:"current-context,omitempty"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+type kubeEntry struct {
+	Name    string    `yaml:"name"`
+	Cluster yaml.Node `yaml:"cluster,omitempty"`
+	User    yaml.Node `yaml:"user,omitempty"`
+	Context yaml.Node `yaml:"context,omitempty"`
+}
+
+// KubernetesProvider gets a service account token from the kubernetes
+// secrets engine and writes it as a context of a kubeconfig file of the
+// profile.
+type KubernetesProvider struct {
+	client  *VaultClient
+	config  config.ProviderConfig
+	options KubernetesConfig
+	creds   *credSet
+}
+
+func NewKubernetesProvider(client *VaultClient, config config.ProviderConfig) (*KubernetesProvider, error) {
+	p := &KubernetesProvider{client: client, config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	if p.config.Backend == "" {
+		p.config.Backend = kubernetesDefaultBackend
+	}
+	if p.options.Context == "" {
+		p.options.Context = fmt.Sprintf("%s-%s", config.Profile, config.ID())
+	}
+	if p.options.File == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		p.options.File = filepath.Join(home, kubernetesConfigFolder, config.Profile+".yaml")
+	}
+	return p, nil
+}
+
+func (p *KubernetesProvider) LoadProfileCreds(info []string) {
+	// The token only lives in the kubeconfig, without it the credentials
+	// can't be used.
+	if p.creds.load(info) {
+		if _, err := os.Stat(p.options.File); err != nil {
+			p.creds.reset()
+		}
+	}
+}
+
+func (p *KubernetesProvider) GenerateCreds() (string, error) {
+	path := fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role)
+	data := map[string]interface{}{"kubernetes_namespace": p.options.Namespace}
+	if p.options.TTL != "" {
+		data["ttl"] = p.options.TTL
+	}
+	if p.options.ClusterRoleBinding {
+		data["cluster_role_binding"] = true
+	}
+	secret, err := p.client.Logical().Write(path, data)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no credentials found in %s", path)
+	}
+	token, _ := secret.Data[kubernetesTokenKey].(string)
+	if err = p.writeConfig(token); err != nil {
+		return "", err
+	}
+	TTL, _ := time.ParseDuration(fmt.Sprintf("%ds", secret.LeaseDuration))
+
+	p.creds.reset()
+	p.creds.set(kubernetesEnvConfigVar, p.options.File)
+	p.creds.TTL = time.Now().Add(TTL)
+	p.creds.lease = secret.LeaseID
+	return token, nil
+}
+
+// writeConfig merges the cluster, user and context of the provider into the
+// kubeconfig file and makes it the current context.
+func (p *KubernetesProvider) writeConfig(token string) error {
+	kube, err := p.readConfig()
+	if err != nil {
+		return err
+	}
+	cluster := map[string]interface{}{"server": p.config.Addr}
+	if p.options.CACert != "" {
+		cluster["certificate-authority"] = p.options.CACert
+	}
+	if p.options.Insecure {
+		cluster["insecure-skip-tls-verify"] = true
+	}
+	user := map[string]interface{}{"token": token}
+	context := map[string]interface{}{"cluster": p.options.Context, "user": p.options.Context, "namespace": p.options.Namespace}
+
+	entry := kubeEntry{Name: p.options.Context}
+	if entry.Cluster, err = kubeNode(cluster); err != nil {
+		return err
+	}
+	kube.Clusters = setKubeEntry(kube.Clusters, entry)
+	entry = kubeEntry{Name: p.options.Context}
+	if entry.User, err = kubeNode(user); err != nil {
+		return err
+	}
+	kube.Users = setKubeEntry(kube.Users, entry)
+	entry = kubeEntry{Name: p.options.Context}
+	if entry.Context, err = kubeNode(context); err != nil {
+		return err
+	}
+	kube.Contexts = setKubeEntry(kube.Contexts, entry)
+	kube.CurrentContext = p.options.Context
+	if err = os.MkdirAll(filepath.Dir(p.options.File), 0700); err != nil {
+		return err
+	}
+	return p.saveConfig(kube)
+}
+
+// removeConfig removes the cluster, user and context of the provider from the
+// kubeconfig file, and the file once it has no entries left.
+func (p *KubernetesProvider) removeConfig() error {
+	kube, err := p.readConfig()
+	if err != nil {
+		return err
+	}
+	kube.Clusters = deleteKubeEntry(kube.Clusters, p.options.Context)
+	kube.Users = deleteKubeEntry(kube.Users, p.options.Context)
+	kube.Contexts = deleteKubeEntry(kube.Contexts, p.options.Context)
+	if kube.CurrentContext == p.options.Context {
+		kube.CurrentContext = ""
+	}
+	if len(kube.Clusters) == 0 && len(kube.Users) == 0 && len(kube.Contexts) == 0 && len(kube.Extra) == 0 {
+		if err = os.Remove(p.options.File); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return p.saveConfig(kube)
+}
+
+func (p *KubernetesProvider) saveConfig(kube kubeConfig) error {
+	content, err := yaml.Marshal(kube)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.options.File, content, 0600)
+}
+
+func (p *KubernetesProvider) readConfig() (kube kubeConfig, err error) {
+	kube = kubeConfig{APIVersion: "v1", Kind: "Config"}
+	content, err := os.ReadFile(p.options.File)
+	if os.IsNotExist(err) {
+		return kube, nil
+	} else if err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(content, &kube); err != nil {
+		err = fmt.Errorf("error reading kubeconfig %s - %s", p.options.File, err)
+	}
+	return
+}
+
+func kubeNode(value interface{}) (node yaml.Node, err error) {
+	err = node.Encode(value)
+	return
+}
+
+// setKubeEntry replaces the entry with the same name or appends it.
+func setKubeEntry(entries []kubeEntry, entry kubeEntry) []kubeEntry {
+	for i, e := range entries {
+		if e.Name == entry.Name {
+			entries[i] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+// deleteKubeEntry removes the entry with the name.
+func deleteKubeEntry(entries []kubeEntry, name string) []kubeEntry {
+	result := []kubeEntry{}
+	for _, e := range entries {
+		if e.Name != name {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func (p *KubernetesProvider) VaultPaths() []VaultPath {
+	return []VaultPath{{Path: fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role), Capability: "update"}}
+}
+
+// RemoveFiles removes the context of the provider from the kubeconfig file.
+func (p *KubernetesProvider) RemoveFiles() error {
+	return p.removeConfig()
+}
+
+// RevokeCreds revokes the lease of the service account token.
+func (p *KubernetesProvider) RevokeCreds() error {
+	return p.creds.revoke(p.client)
+}
+
+func (p *KubernetesProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *KubernetesProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *KubernetesProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *KubernetesProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
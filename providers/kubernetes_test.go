@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKubernetesRemoveKeepsOtherEntries(t *testing.T) {
+	client := testVault(t, map[string]interface{}{
+		"/v1/kubernetes/creds/dev": map[string]interface{}{
+			"lease_id":       "kubernetes/creds/dev/abc",
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"service_account_token": "sa-token"},
+		},
+		"/v1/sys/leases/revoke": map[string]interface{}{},
+	})
+	file := filepath.Join(t.TempDir(), "config")
+	shared := `apiVersion: v1
+kind: Config
+clusters:
+- name: other
+  cluster:
+    server: https://other:6443
+users:
+- name: other
+  user:
+    token: other-token
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+current-context: other
+`
+	if err := os.WriteFile(file, []byte(shared), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := testProvider(t, "kubernetes", "", fmt.Sprintf("role: dev\nnamespace: apps\nfile: %s", file))
+	config.Addr = "https://dev:6443"
+	provider, err := NewKubernetesProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(file)
+	if !strings.Contains(string(content), "sa-token") || !strings.Contains(string(content), "other-token") {
+		t.Fatalf("expected both contexts in the kubeconfig, got\n%s", content)
+	}
+
+	if err = provider.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	content, err = os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("expected the shared kubeconfig to be kept - %s", err)
+	}
+	if strings.Contains(string(content), "sa-token") || strings.Contains(string(content), "test-kubernetes") ||
+		!strings.Contains(string(content), "other-token") {
+		t.Fatalf("expected only the entries of the provider to be removed, got\n%s", content)
+	}
+}
+
+func TestKubernetesRemoveDeletesEmptyFile(t *testing.T) {
+	client := testVault(t, map[string]interface{}{
+		"/v1/kubernetes/creds/dev": map[string]interface{}{
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"service_account_token": "sa-token"},
+		},
+	})
+	file := filepath.Join(t.TempDir(), "kube", "test.yaml")
+	config := testProvider(t, "kubernetes", "", fmt.Sprintf("role: dev\nnamespace: apps\nfile: %s", file))
+	config.Addr = "https://dev:6443"
+	provider, err := NewKubernetesProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if err = provider.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("expected the kubeconfig to be removed - %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const (
+	mountsInfoPath = "sys/internal/ui/mounts/"
+)
+
+// kvPath is a path of a KV secrets engine split in its mount and the path of
+// the secret inside it.
+type kvPath struct {
+	mount   string
+	path    string
+	version int
+}
+
+// kvPath resolves the mount and KV version of the path, unless they are
+// configured, from the mount tuning. Without access to it the path is read
+// as a KV v1 path.
+func (c *VaultClient) kvPath(path string, mount string, version int) kvPath {
+	path = strings.Trim(path, "/")
+	result := kvPath{mount: strings.Trim(mount, "/"), version: version}
+	if result.mount == "" || result.version == 0 {
+		if secret, err := c.Logical().Read(mountsInfoPath + path); err == nil && secret != nil {
+			if result.mount == "" {
+				result.mount = strings.Trim(fmt.Sprint(secret.Data["path"]), "/")
+			}
+			if result.version == 0 {
+				if options, ok := secret.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+					result.version = 2
+				}
+			}
+		}
+	}
+	if result.version == 0 {
+		result.version = 1
+	}
+	result.path = strings.TrimPrefix(strings.TrimPrefix(path, result.mount), "/")
+	if result.version == 2 {
+		result.path = strings.TrimPrefix(result.path, "data/")
+	}
+	return result
+}
+
+// readKV reads the data of the secret and its metadata. With KV v1 there's
+// no metadata and the secret version can't be pinned.
+func (c *VaultClient) readKV(p kvPath, secretVersion int, withMetadata bool) (data map[string]interface{}, metadata map[string]interface{}, err error) {
+	metadata = map[string]interface{}{}
+	if p.version != 2 {
+		if secretVersion != 0 {
+			return nil, nil, fmt.Errorf("version can't be pinned in KV v1 mount %s", p.mount)
+		}
+		path := strings.TrimPrefix(fmt.Sprintf("%s/%s", p.mount, p.path), "/")
+		secret, err := c.Logical().Read(path)
+		if err != nil || secret == nil {
+			return nil, nil, err
+		}
+		return secret.Data, metadata, nil
+	}
+
+	kv := c.KVv2(p.mount)
+	ctx := context.Background()
+	var secret *vault.KVSecret
+	if secretVersion != 0 {
+		secret, err = kv.GetVersion(ctx, p.path, secretVersion)
+	} else {
+		secret, err = kv.Get(ctx, p.path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret.VersionMetadata != nil {
+		metadata["version"] = secret.VersionMetadata.Version
+		metadata["created_time"] = secret.VersionMetadata.CreatedTime.Format(time.RFC3339)
+	}
+	for key, value := range secret.CustomMetadata {
+		metadata[fmt.Sprintf("custom_metadata.%s", key)] = value
+	}
+	if withMetadata {
+		meta, err := kv.GetMetadata(ctx, p.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		metadata["updated_time"] = meta.UpdatedTime.Format(time.RFC3339)
+		metadata["current_version"] = meta.CurrentVersion
+		metadata["oldest_version"] = meta.OldestVersion
+	}
+	return secret.Data, metadata, nil
+}
+
+// listKV returns the entries of the folder, the ones ending with / are
+// folders themselves.
+func (c *VaultClient) listKV(p kvPath) ([]string, error) {
+	path := fmt.Sprintf("%s/%s", p.mount, p.path)
+	if p.version == 2 {
+		path = fmt.Sprintf("%s/metadata/%s", p.mount, p.path)
+	}
+	secret, err := c.Logical().List(strings.TrimPrefix(path, "/"))
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	entries := []string{}
+	for _, key := range keys {
+		entries = append(entries, fmt.Sprint(key))
+	}
+	return entries, nil
+}
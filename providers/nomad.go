@@ -2,28 +2,98 @@ package providers
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/smorenodp/clusterprofile/config"
 )
 
 const (
-	nomadRoleDataKey = "secret_id"
-	nomadEnvTokenVar = "NOMAD_TOKEN"
-	nomadEnvAddrVar  = "NOMAD_ADDR"
-	nomadEnvTTLVar   = "NOMAD_TTL"
+	nomadRoleDataKey         = "secret_id"
+	nomadEnvTokenVar         = "NOMAD_TOKEN"
+	nomadEnvAddrVar          = "NOMAD_ADDR"
+	nomadEnvTTLVar           = "NOMAD_TTL"
+	nomadEnvNamespaceVar     = "NOMAD_NAMESPACE"
+	nomadEnvRegionVar        = "NOMAD_REGION"
+	nomadEnvCACertVar        = "NOMAD_CACERT"
+	nomadEnvClientCertVar    = "NOMAD_CLIENT_CERT"
+	nomadEnvClientKeyVar     = "NOMAD_CLIENT_KEY"
+	nomadEnvTLSServerNameVar = "NOMAD_TLS_SERVER_NAME"
+	nomadOIDCCallbackPort    = 4649
+	// nomadTokenTTL is how long the tokens of the auth methods without an
+	// expiration time are reused.
+	nomadTokenTTL = 8 * time.Hour
 )
 
+func init() {
+	Register("nomad", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewNomadProvider(client, config)
+	})
+	RegisterSpec("nomad", Spec{
+		Methods: []string{"role", "token", "oidc", "jwt"},
+		Config:  NomadConfig{},
+		Keys: map[string][]string{"": {"namespace", "region", "ca_cert", "client_cert", "client_key", "tls_server_name", "verify"},
+			"role": {"role"}, "token": {"token"},
+			"oidc": {"auth_method", "callback_port"}, "jwt": {"auth_method", "jwt", "jwt_file"}},
+		Required: map[string][]string{"role": {"backend", "config.role"}, "token": {"config.token"},
+			"oidc": {"addr", "config.auth_method"}, "jwt": {"addr", "config.auth_method"}},
+		Validate: func(p config.ProviderConfig, options interface{}) []config.FieldError {
+			if options.(*NomadConfig).Verify && p.Addr == "" {
+				return []config.FieldError{{Key: "addr", Msg: "addr is required with verify"}}
+			}
+			return nil
+		},
+	})
+}
+
+type NomadConfig struct {
+	Role          string `yaml:"role"`
+	Token         string `yaml:"token"`
+	AuthMethod    string `yaml:"auth_method"`
+	JWT           string `yaml:"jwt"`
+	JWTFile       string `yaml:"jwt_file"`
+	CallbackPort  int    `yaml:"callback_port"`
+	Namespace     string `yaml:"namespace"`
+	Region        string `yaml:"region"`
+	CACert        string `yaml:"ca_cert"`
+	ClientCert    string `yaml:"client_cert"`
+	ClientKey     string `yaml:"client_key"`
+	TLSServerName string `yaml:"tls_server_name"`
+	Verify        bool   `yaml:"verify"`
+}
+
+// nomadACLToken is the token returned by the login endpoints of Nomad.
+type nomadACLToken struct {
+	AccessorID     string
+	SecretID       string
+	ExpirationTime *time.Time
+}
+
 type NomadProvider struct {
-	client *VaultClient
-	config config.ProviderConfig
-	token  string
-	TTL    time.Time
+	client  *VaultClient
+	api     *http.Client
+	config  config.ProviderConfig
+	options NomadConfig
+	token   string
+	TTL     time.Time
+	stored  bool
 }
 
-func NewNomadProvider(client *VaultClient, config config.ProviderConfig) *NomadProvider {
-	return &NomadProvider{client: client, config: config}
+func NewNomadProvider(client *VaultClient, config config.ProviderConfig) (*NomadProvider, error) {
+	p := &NomadProvider{client: client, config: config}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *NomadProvider) tls() tlsConfig {
+	return tlsConfig{CACert: p.options.CACert, ClientCert: p.options.ClientCert,
+		ClientKey: p.options.ClientKey, ServerName: p.options.TLSServerName}
 }
 
 func (p *NomadProvider) LoadProfileCreds(info []string) {
@@ -35,9 +105,10 @@ func (p *NomadProvider) LoadProfileCreds(info []string) {
 		if matches := tokenRegex.FindStringSubmatch(i); matches != nil {
 			token = matches[1]
 		} else if matches := ttlRegex.FindStringSubmatch(i); matches != nil {
-			ttl, _ = time.Parse(time.Layout, matches[1])
+			ttl, _ = time.Parse(layout, matches[1])
 		}
 	}
+	p.stored = token != ""
 	if time.Now().Before(ttl) {
 		p.token = token
 		p.TTL = ttl
@@ -49,7 +120,7 @@ func (p *NomadProvider) credsFromRole() (string, error) {
 	if p.token != "" {
 		return p.token, nil
 	}
-	path := fmt.Sprintf("%s/creds/%s", p.config.Backend, p.config.Config.Role)
+	path := fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role)
 	secret, err := p.client.Logical().Read(path)
 	if err != nil {
 		return "", err
@@ -64,33 +135,144 @@ func (p *NomadProvider) credsFromRole() (string, error) {
 }
 
 func (p *NomadProvider) credsFromToken() (string, error) {
-	p.token = p.config.Config.Token
+	p.token = p.options.Token
 	return p.token, nil
 }
 
-func (p *NomadProvider) GenerateCreds() (string, error) {
+// credsFromOIDC logs in with an OIDC auth method of Nomad in the browser.
+func (p *NomadProvider) credsFromOIDC() (string, error) {
+	addr := serviceURL(p.config.Addr)
+	port := p.options.CallbackPort
+	if port == 0 {
+		port = nomadOIDCCallbackPort
+	}
+	result, err := oidcLogin(port, func(redirect string, nonce string) (string, error) {
+		var response struct{ AuthURL string }
+		request := map[string]string{"AuthMethodName": p.options.AuthMethod, "RedirectURI": redirect, "ClientNonce": nonce}
+		err := apiRequest(p.api, http.MethodPost, addr+"/v1/acl/oidc/auth-url", nil, request, &response)
+		return response.AuthURL, err
+	})
+	if err != nil {
+		return "", err
+	}
+	var token nomadACLToken
+	request := map[string]string{"AuthMethodName": p.options.AuthMethod, "ClientNonce": result.Nonce,
+		"State": result.State, "Code": result.Code, "RedirectURI": result.URI}
+	if err = apiRequest(p.api, http.MethodPost, addr+"/v1/acl/oidc/complete-auth", nil, request, &token); err != nil {
+		return "", fmt.Errorf("error completing nomad oidc login - %s", err)
+	}
+	return p.setACLToken(token), nil
+}
+
+// credsFromJWT logs in with a JWT auth method of Nomad, the JWT is
+// configured or read from a file.
+func (p *NomadProvider) credsFromJWT() (string, error) {
+	jwt := p.options.JWT
+	if p.options.JWTFile != "" {
+		content, err := os.ReadFile(p.options.JWTFile)
+		if err != nil {
+			return "", err
+		}
+		jwt = strings.TrimSpace(string(content))
+	}
+	if jwt == "" {
+		return "", fmt.Errorf("jwt or jwt_file is required for method jwt")
+	}
+	var token nomadACLToken
+	request := map[string]string{"AuthMethodName": p.options.AuthMethod, "LoginToken": jwt}
+	if err := apiRequest(p.api, http.MethodPost, serviceURL(p.config.Addr)+"/v1/acl/login", nil, request, &token); err != nil {
+		return "", fmt.Errorf("error in nomad jwt login - %s", err)
+	}
+	return p.setACLToken(token), nil
+}
+
+func (p *NomadProvider) setACLToken(token nomadACLToken) string {
+	p.token = token.SecretID
+	p.TTL = time.Now().Add(nomadTokenTTL)
+	if token.ExpirationTime != nil {
+		p.TTL = token.ExpirationTime.Local()
+	}
+	return p.token
+}
+
+func (p *NomadProvider) VaultPaths() []VaultPath {
+	if p.config.Method != "role" {
+		return nil
+	}
+	return []VaultPath{{Path: fmt.Sprintf("%s/creds/%s", p.config.Backend, p.options.Role), Capability: "read"}}
+}
+
+func (p *NomadProvider) GenerateCreds() (token string, err error) {
+	if p.options.Verify && p.config.Addr == "" {
+		return "", fmt.Errorf("addr is required to verify the nomad token")
+	}
+	if p.api, err = p.tls().client(); err != nil {
+		return "", err
+	}
 	switch p.config.Method {
 	case "role":
-		return p.credsFromRole()
+		token, err = p.credsFromRole()
 	case "token":
-		return p.credsFromToken()
+		token, err = p.credsFromToken()
+	case "oidc":
+		token, err = p.credsFromOIDC()
+	case "jwt":
+		token, err = p.credsFromJWT()
 	default:
 		return "", fmt.Errorf("method %s not implemented yet", p.config.Method)
 	}
+	if err == nil && p.options.Verify {
+		err = p.verify()
+	}
+	return
+}
+
+// verify checks that Nomad accepts the token, discarding it otherwise.
+func (p *NomadProvider) verify() error {
+	path := serviceURL(p.config.Addr) + "/v1/acl/token/self"
+	if p.options.Region != "" {
+		path += "?region=" + url.QueryEscape(p.options.Region)
+	}
+	if err := apiRequest(p.api, http.MethodGet, path, map[string]string{nomadTokenHeader: p.token}, nil, nil); err != nil {
+		p.token, p.TTL = "", time.Time{}
+		return fmt.Errorf("error verifying nomad token - %s", err)
+	}
+	return nil
 }
 
-func (p *NomadProvider) ExportCreds() []string {
-	return []string{fmt.Sprintf("export %s=%q", nomadEnvTokenVar, p.token),
-		fmt.Sprintf("export %s=%q", nomadEnvTTLVar, p.TTL.Format(layout)),
-		fmt.Sprintf("export %s=%q", nomadEnvAddrVar, p.config.Addr)}
+// envVars returns the variables of the provider, the optional ones only when
+// they are configured.
+func (p *NomadProvider) envVars() [][2]string {
+	vars := [][2]string{{nomadEnvTokenVar, p.token}, {nomadEnvTTLVar, p.TTL.Format(layout)}, {nomadEnvAddrVar, p.config.Addr}}
+	optional := [][2]string{{nomadEnvNamespaceVar, p.options.Namespace}, {nomadEnvRegionVar, p.options.Region},
+		{nomadEnvCACertVar, p.options.CACert}, {nomadEnvClientCertVar, p.options.ClientCert},
+		{nomadEnvClientKeyVar, p.options.ClientKey}, {nomadEnvTLSServerNameVar, p.options.TLSServerName}}
+	for _, v := range optional {
+		if v[1] != "" {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+func (p *NomadProvider) ExportCreds() (export []string) {
+	for _, v := range p.envVars() {
+		export = append(export, fmt.Sprintf("export %s=%q", v[0], v[1]))
+	}
+	return
 }
 
 func (p *NomadProvider) CredsLoaded() bool {
 	return p.token != ""
 }
 
-func (p *NomadProvider) ProfileCreds() []string {
-	return []string{fmt.Sprintf("%s=%q", nomadEnvTokenVar, p.token),
-		fmt.Sprintf("%s=%q", nomadEnvTTLVar, p.TTL.Format(layout)),
-		fmt.Sprintf("%s=%q", nomadEnvAddrVar, p.config.Addr)}
+func (p *NomadProvider) CacheState() CacheState {
+	return CacheState{Stored: p.stored, Expires: p.TTL}
+}
+
+func (p *NomadProvider) ProfileCreds() (creds []string) {
+	for _, v := range p.envVars() {
+		creds = append(creds, fmt.Sprintf("%s=%q", v[0], v[1]))
+	}
+	return
 }
@@ -0,0 +1,110 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNomadJWT(t *testing.T) {
+	expiration := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name       string
+		expiration *time.Time
+		ttl        time.Duration
+	}{
+		{"expiration time", &expiration, 2 * time.Hour},
+		{"no expiration time", nil, nomadTokenTTL},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := testService(t, map[string]func(*http.Request) interface{}{
+				"/v1/acl/login": func(r *http.Request) interface{} {
+					var request map[string]string
+					json.NewDecoder(r.Body).Decode(&request)
+					if request["AuthMethodName"] != "ci" || request["LoginToken"] != "jwt" {
+						t.Errorf("unexpected login request %v", request)
+					}
+					return map[string]interface{}{"SecretID": "nomad-token", "ExpirationTime": test.expiration}
+				},
+			})
+			file := filepath.Join(t.TempDir(), "jwt")
+			if err := os.WriteFile(file, []byte("jwt\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			config := testProvider(t, "nomad", "jwt", fmt.Sprintf("auth_method: ci\njwt_file: %s", file))
+			config.Addr = server.URL
+			provider, err := NewNomadProvider(nil, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err != nil {
+				t.Fatal(err)
+			}
+			if provider.token != "nomad-token" || time.Until(provider.TTL).Round(time.Hour) != test.ttl {
+				t.Fatalf("expected the jwt token for %s, got %s until %s", test.ttl, provider.token, provider.TTL)
+			}
+
+			// The expiration is stored and the token reused until then.
+			cached, _ := NewNomadProvider(nil, config)
+			if cached.LoadProfileCreds(provider.ProfileCreds()); !cached.CredsLoaded() || !cached.TTL.Equal(provider.TTL.Truncate(time.Second)) {
+				t.Fatalf("expected the cached token until %s, got %s until %s", provider.TTL, cached.token, cached.TTL)
+			}
+		})
+	}
+}
+
+func TestNomadJWTMissing(t *testing.T) {
+	config := testProvider(t, "nomad", "jwt", "auth_method: ci")
+	config.Addr = "http://127.0.0.1:4646"
+	provider, _ := NewNomadProvider(nil, config)
+	if _, err := provider.GenerateCreds(); err == nil || err.Error() != "jwt or jwt_file is required for method jwt" {
+		t.Fatalf("expected an error without jwt, got %v", err)
+	}
+}
+
+func TestNomadOIDC(t *testing.T) {
+	browser(t)
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	nonce := ""
+	server := testService(t, map[string]func(*http.Request) interface{}{
+		"/v1/acl/oidc/auth-url": func(r *http.Request) interface{} {
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			nonce = request["ClientNonce"]
+			if request["AuthMethodName"] != "sso" || request["RedirectURI"] != "http://localhost:4649/oidc/callback" {
+				t.Errorf("unexpected auth-url request %v", request)
+			}
+			return map[string]string{"AuthURL": request["RedirectURI"] + "?code=test-code&state=test-state"}
+		},
+		"/v1/acl/oidc/complete-auth": func(r *http.Request) interface{} {
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			if request["AuthMethodName"] != "sso" || request["Code"] != "test-code" || request["State"] != "test-state" ||
+				request["ClientNonce"] != nonce || request["RedirectURI"] != "http://localhost:4649/oidc/callback" {
+				t.Errorf("unexpected complete-auth request %v", request)
+			}
+			return map[string]interface{}{"SecretID": "oidc-token", "ExpirationTime": expiration}
+		},
+	})
+	config := testProvider(t, "nomad", "oidc", "auth_method: sso")
+	config.Addr = server.URL
+	provider, err := NewNomadProvider(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if provider.token != "oidc-token" || !provider.TTL.Equal(expiration) {
+		t.Fatalf("expected the oidc token until %s, got %s until %s", expiration, provider.token, provider.TTL)
+	}
+	if export := exported(provider); !strings.Contains(export, `NOMAD_TOKEN="oidc-token"`) || !strings.Contains(export, fmt.Sprintf("NOMAD_ADDR=%q", server.URL)) {
+		t.Fatalf("expected the token and address, got %s", export)
+	}
+}
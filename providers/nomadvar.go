@@ -0,0 +1,154 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	nomadTokenHeader = "X-Nomad-Token"
+)
+
+func init() {
+	Register("nomad_var", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewNomadVarProvider(client, config)
+	})
+	RegisterSpec("nomad_var", Spec{
+		Config:   NomadVarConfig{},
+		Required: map[string][]string{"": {"config.path", "config.secret_map"}},
+		Depends: func(config config.ProviderConfig) []string {
+			var options NomadVarConfig
+			if config.Decode(&options) != nil {
+				return nil
+			}
+			return []string{nomadVarProvider(options)}
+		},
+	})
+}
+
+// nomadVarProvider returns the provider whose token is used when the address
+// or the token are not configured, nomad by default.
+func nomadVarProvider(options NomadVarConfig) string {
+	if options.Provider == "" {
+		return "nomad"
+	}
+	return options.Provider
+}
+
+type NomadVarConfig struct {
+	Path      string            `yaml:"path"`
+	Namespace string            `yaml:"namespace"`
+	SecretMap map[string]string `yaml:"secret_map"`
+	Token     string            `yaml:"token"`
+	Provider  string            `yaml:"provider"`
+}
+
+// nomadVariable is the response of the Nomad variables API.
+type nomadVariable struct {
+	Namespace string
+	Path      string
+	Items     map[string]string
+}
+
+// NomadVarProvider reads a Nomad variable with the token of the nomad
+// provider of the profile.
+type NomadVarProvider struct {
+	config  config.ProviderConfig
+	options NomadVarConfig
+	outputs map[string]string
+	creds   *credSet
+}
+
+func NewNomadVarProvider(client *VaultClient, config config.ProviderConfig) (*NomadVarProvider, error) {
+	p := &NomadVarProvider{config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	p.options.Provider = nomadVarProvider(p.options)
+	return p, nil
+}
+
+// SetOutputs keeps the variables of the nomad provider, the token and
+// address used to read the variable.
+func (p *NomadVarProvider) SetOutputs(outputs config.Outputs) {
+	p.outputs = outputs[p.options.Provider]
+}
+
+func (p *NomadVarProvider) LoadProfileCreds(info []string) {
+	p.creds.load(info)
+}
+
+// connection returns the address and token of Nomad, the configured ones
+// or the ones of the nomad provider.
+func (p *NomadVarProvider) connection() (addr string, token string, err error) {
+	addr, token = p.config.Addr, p.options.Token
+	if addr == "" {
+		addr = p.outputs[nomadEnvAddrVar]
+	}
+	if token == "" {
+		token = p.outputs[nomadEnvTokenVar]
+	}
+	if addr == "" || token == "" {
+		err = fmt.Errorf("no nomad address or token, configure them or load the %s provider before", p.options.Provider)
+	}
+	return
+}
+
+func (p *NomadVarProvider) GenerateCreds() (string, error) {
+	addr, token, err := p.connection()
+	if err != nil {
+		return "", err
+	}
+	// The TLS configuration is the one of the nomad provider.
+	client, err := tlsConfig{CACert: p.outputs[nomadEnvCACertVar], ClientCert: p.outputs[nomadEnvClientCertVar],
+		ClientKey: p.outputs[nomadEnvClientKeyVar], ServerName: p.outputs[nomadEnvTLSServerNameVar]}.client()
+	if err != nil {
+		return "", err
+	}
+	namespace := p.options.Namespace
+	if namespace == "" {
+		namespace = p.outputs[nomadEnvNamespaceVar]
+	}
+	path := fmt.Sprintf("%s/v1/var/%s", serviceURL(addr), strings.TrimPrefix(p.options.Path, "/"))
+	if namespace != "" {
+		path += "?namespace=" + url.QueryEscape(namespace)
+	}
+	var variable nomadVariable
+	if err = apiRequest(client, http.MethodGet, path, map[string]string{nomadTokenHeader: token}, nil, &variable); err != nil {
+		return "", fmt.Errorf("error reading nomad variable %s - %s", p.options.Path, err)
+	}
+	vars := map[string]string{}
+	for item, envName := range p.options.SecretMap {
+		value, ok := variable.Items[item]
+		if !ok {
+			return "", fmt.Errorf("item %s not found in nomad variable %s", item, p.options.Path)
+		}
+		vars[envName] = value
+	}
+	p.creds.reset()
+	p.creds.setAll(vars)
+	// The values are reused while the token that read them is valid.
+	p.creds.TTL, _ = time.Parse(layout, p.outputs[nomadEnvTTLVar])
+	return "", nil
+}
+
+func (p *NomadVarProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *NomadVarProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *NomadVarProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *NomadVarProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
@@ -0,0 +1,93 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const (
+	oidcCallbackPath = "/oidc/callback"
+	oidcTimeout      = 5 * time.Minute
+)
+
+// oidcResult is the authorization received in the callback of the browser.
+type oidcResult struct {
+	Code  string
+	State string
+	Nonce string
+	URI   string
+}
+
+// oidcLogin runs the OIDC flow of Nomad and Consul auth methods: it listens
+// on a localhost port, the default one of each CLI unless configured, asks
+// authURL for the URL of the provider for the redirect URI and the client
+// nonce, opens it in the browser and waits for the redirection with the code
+// and the state.
+func oidcLogin(port int, authURL func(redirect string, nonce string) (string, error)) (result oidcResult, err error) {
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	result.Nonce = hex.EncodeToString(nonce)
+	result.URI = fmt.Sprintf("http://localhost:%d%s", port, oidcCallbackPath)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return result, fmt.Errorf("error listening for the oidc callback - %s", err)
+	}
+	results := make(chan oidcResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("code") == "" {
+			http.Error(w, "missing code", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "Login completed, you can close this window.")
+		select {
+		case results <- oidcResult{Code: query.Get("code"), State: query.Get("state")}:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	url, err := authURL(result.URI, result.Nonce)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Complete the login in your browser: %s\n", url)
+	openBrowser(url)
+
+	select {
+	case received := <-results:
+		result.Code, result.State = received.Code, received.State
+		return result, nil
+	case <-time.After(oidcTimeout):
+		return result, fmt.Errorf("timeout waiting for the oidc callback")
+	}
+}
+
+// openBrowser opens the url in the browser of the user, it's replaced in the
+// tests.
+var openBrowser = func(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	cmd.Start()
+}
@@ -0,0 +1,224 @@
+package providers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+const (
+	pkiDefaultBackend = "pki"
+	pkiFolder         = ".clusterid/pki"
+	pkiRenewFraction  = 3
+)
+
+// pkiTargetVars are the variables with the certificate, key and CA files of
+// each tool.
+var pkiTargetVars = map[string][3]string{
+	"nomad":  {"NOMAD_CLIENT_CERT", "NOMAD_CLIENT_KEY", "NOMAD_CACERT"},
+	"consul": {"CONSUL_CLIENT_CERT", "CONSUL_CLIENT_KEY", "CONSUL_CACERT"},
+	"vault":  {"VAULT_CLIENT_CERT", "VAULT_CLIENT_KEY", "VAULT_CACERT"},
+}
+
+func init() {
+	Register("pki", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewPKIProvider(client, config)
+	})
+	RegisterSpec("pki", Spec{
+		Config:   PKIConfig{},
+		Required: map[string][]string{"": {"config.role", "config.common_name"}},
+	})
+}
+
+type PKIConfig struct {
+	Role        string   `yaml:"role"`
+	CommonName  string   `yaml:"common_name"`
+	AltNames    []string `yaml:"alt_names"`
+	IPSANs      []string `yaml:"ip_sans"`
+	TTL         string   `yaml:"ttl"`
+	Dir         string   `yaml:"dir"`
+	Targets     []string `yaml:"targets"`
+	RenewBefore string   `yaml:"renew_before"`
+}
+
+// PKIProvider issues a client certificate with the PKI secrets engine and
+// writes it, with its key and CA chain, to a folder of the profile.
+type PKIProvider struct {
+	client  *VaultClient
+	config  config.ProviderConfig
+	options PKIConfig
+	creds   *credSet
+}
+
+func NewPKIProvider(client *VaultClient, config config.ProviderConfig) (*PKIProvider, error) {
+	p := &PKIProvider{client: client, config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	if p.config.Backend == "" {
+		p.config.Backend = pkiDefaultBackend
+	}
+	if p.options.Dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		p.options.Dir = filepath.Join(home, pkiFolder, config.Profile)
+	}
+	if len(p.options.Targets) == 0 {
+		p.options.Targets = []string{"nomad", "consul"}
+	}
+	for _, target := range p.options.Targets {
+		if _, ok := pkiTargetVars[target]; !ok {
+			return nil, fmt.Errorf("pki target %s not implemented", target)
+		}
+	}
+	if p.options.RenewBefore != "" {
+		if _, err := time.ParseDuration(p.options.RenewBefore); err != nil {
+			return nil, fmt.Errorf("invalid renew_before %s - %s", p.options.RenewBefore, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *PKIProvider) files() (cert string, key string, ca string) {
+	prefix := filepath.Join(p.options.Dir, p.config.ID())
+	return prefix + "-cert.pem", prefix + "-key.pem", prefix + "-ca.pem"
+}
+
+func (p *PKIProvider) LoadProfileCreds(info []string) {
+	if !p.creds.load(info) {
+		return
+	}
+	cert, key, ca := p.files()
+	for _, file := range []string{cert, key, ca} {
+		if _, err := os.Stat(file); err != nil {
+			p.creds.reset()
+			return
+		}
+	}
+}
+
+func (p *PKIProvider) GenerateCreds() (string, error) {
+	data := map[string]interface{}{"common_name": p.options.CommonName}
+	if len(p.options.AltNames) > 0 {
+		data["alt_names"] = strings.Join(p.options.AltNames, ",")
+	}
+	if len(p.options.IPSANs) > 0 {
+		data["ip_sans"] = strings.Join(p.options.IPSANs, ",")
+	}
+	if p.options.TTL != "" {
+		data["ttl"] = p.options.TTL
+	}
+	path := fmt.Sprintf("%s/issue/%s", p.config.Backend, p.options.Role)
+	secret, err := p.client.Logical().Write(path, data)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no certificate returned by %s", path)
+	}
+	certificate, _ := secret.Data["certificate"].(string)
+	privateKey, _ := secret.Data["private_key"].(string)
+	chain := []string{}
+	if caChain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			chain = append(chain, fmt.Sprint(ca))
+		}
+	}
+	if issuing, ok := secret.Data["issuing_ca"].(string); ok && len(chain) == 0 {
+		chain = append(chain, issuing)
+	}
+	notBefore, notAfter, err := certValidity(certificate)
+	if err != nil {
+		return "", err
+	}
+
+	certFile, keyFile, caFile := p.files()
+	if err = os.MkdirAll(p.options.Dir, 0700); err != nil {
+		return "", err
+	}
+	contents := map[string]string{certFile: certificate, keyFile: privateKey, caFile: strings.Join(chain, "\n")}
+	for file, content := range contents {
+		if err = os.WriteFile(file, []byte(strings.TrimSpace(content)+"\n"), 0600); err != nil {
+			return "", fmt.Errorf("error writing %s - %s", file, err)
+		}
+		// The mode is only set by WriteFile when the file is created.
+		if err = os.Chmod(file, 0600); err != nil {
+			return "", err
+		}
+	}
+
+	p.creds.reset()
+	for _, target := range p.options.Targets {
+		vars := pkiTargetVars[target]
+		p.creds.set(vars[0], certFile)
+		p.creds.set(vars[1], keyFile)
+		p.creds.set(vars[2], caFile)
+	}
+	p.creds.TTL = notAfter.Add(-p.renewBefore(notAfter.Sub(notBefore)))
+	return certificate, nil
+}
+
+// renewBefore returns how long before the certificate expires it's issued
+// again, a third of its validity by default.
+func (p *PKIProvider) renewBefore(validity time.Duration) time.Duration {
+	if renew, err := time.ParseDuration(p.options.RenewBefore); err == nil {
+		return renew
+	}
+	return validity / pkiRenewFraction
+}
+
+func certValidity(certificate string) (notBefore time.Time, notAfter time.Time, err error) {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return notBefore, notAfter, fmt.Errorf("no certificate found in the response")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return
+	}
+	return cert.NotBefore, cert.NotAfter, nil
+}
+
+func (p *PKIProvider) VaultPaths() []VaultPath {
+	return []VaultPath{{Path: fmt.Sprintf("%s/issue/%s", p.config.Backend, p.options.Role), Capability: "update"}}
+}
+
+// RemoveFiles deletes the certificate, key and CA files.
+func (p *PKIProvider) RemoveFiles() error {
+	cert, key, ca := p.files()
+	for _, file := range []string{cert, key, ca} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeCreds does nothing, the certificates expire by themselves.
+func (p *PKIProvider) RevokeCreds() error {
+	return nil
+}
+
+func (p *PKIProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *PKIProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *PKIProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *PKIProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
@@ -0,0 +1,158 @@
+package providers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pkiVault answers the pki role client issuing a self-signed certificate
+// valid for the validity, and records the requests.
+func pkiVault(t *testing.T, validity time.Duration, requests *[]map[string]interface{}) *VaultClient {
+	t.Helper()
+	return testVault(t, map[string]interface{}{
+		"/v1/pki/issue/client": func(r *http.Request) interface{} {
+			request := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&request)
+			*requests = append(*requests, request)
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				return http.StatusBadRequest
+			}
+			now := time.Now().Truncate(time.Second)
+			template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: fmt.Sprint(request["common_name"])},
+				NotBefore: now, NotAfter: now.Add(validity)}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			if err != nil {
+				return http.StatusBadRequest
+			}
+			keyDer, _ := x509.MarshalECPrivateKey(key)
+			return map[string]interface{}{"data": map[string]interface{}{
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+				"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+				"ca_chain":    []string{"CA1", "CA2"},
+				"issuing_ca":  "CA1",
+			}}
+		},
+	})
+}
+
+func TestPKIProviderIssue(t *testing.T) {
+	requests := []map[string]interface{}{}
+	client := pkiVault(t, 3*time.Hour, &requests)
+	dir := t.TempDir()
+	config := testProvider(t, "pki", "", fmt.Sprintf("role: client\ncommon_name: dev.client\nalt_names: [a, b]\nttl: 3h\ndir: %s", dir))
+	provider, err := NewPKIProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, ca := provider.files()
+	// The files written before with other permissions are restricted.
+	if err = os.WriteFile(key, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0]["common_name"] != "dev.client" || requests[0]["alt_names"] != "a,b" || requests[0]["ttl"] != "3h" {
+		t.Fatalf("unexpected issue request %v", requests)
+	}
+	for _, file := range []string{cert, key, ca} {
+		if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("expected %s with mode 0600 - %v", file, err)
+		}
+	}
+	if content, _ := os.ReadFile(ca); string(content) != "CA1\nCA2\n" {
+		t.Fatalf("expected the CA chain, got %q", content)
+	}
+	if content, _ := os.ReadFile(key); !strings.Contains(string(content), "EC PRIVATE KEY") {
+		t.Fatalf("expected the private key, got %q", content)
+	}
+	expected := fmt.Sprintf(`CONSUL_CACERT="%s",CONSUL_CLIENT_CERT="%s",CONSUL_CLIENT_KEY="%s",NOMAD_CACERT="%s",NOMAD_CLIENT_CERT="%s",NOMAD_CLIENT_KEY="%s"`,
+		ca, cert, key, ca, cert, key)
+	if got := exported(provider); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	cached, _ := NewPKIProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); !cached.CredsLoaded() {
+		t.Fatal("expected the cached certificate to be reused")
+	}
+	if err = cached.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{cert, key, ca} {
+		if _, err = os.Stat(file); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed - %v", file, err)
+		}
+	}
+	cached, _ = NewPKIProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); cached.CredsLoaded() {
+		t.Fatal("expected the certificate to be issued again without its files")
+	}
+}
+
+func TestPKIProviderRenew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		renew  time.Duration
+	}{
+		{"a third of the validity", "", time.Hour},
+		{"renew_before", "renew_before: 30m\n", 30 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := pkiVault(t, 3*time.Hour, &[]map[string]interface{}{})
+			config := testProvider(t, "pki", "", fmt.Sprintf("role: client\ncommon_name: dev\ntargets: [vault]\n%sdir: %s", test.config, t.TempDir()))
+			provider, err := NewPKIProvider(client, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err != nil {
+				t.Fatal(err)
+			}
+			expected := time.Now().Add(3*time.Hour - test.renew)
+			if expires := provider.CacheState().Expires; expires.Sub(expected).Abs() > 5*time.Second {
+				t.Fatalf("expected to renew at %s, got %s", expected, expires)
+			}
+			if !strings.HasPrefix(exported(provider), "VAULT_CACERT=") {
+				t.Fatalf("expected the vault variables, got %s", exported(provider))
+			}
+		})
+	}
+	if _, err := NewPKIProvider(nil, testProvider(t, "pki", "", "role: client\ncommon_name: dev\nrenew_before: soon\ndir: /tmp")); err == nil {
+		t.Fatal("expected an error with an invalid renew_before")
+	}
+}
+
+func TestPKIProviderExpiredCacheRemovesFiles(t *testing.T) {
+	client := pkiVault(t, 0, &[]map[string]interface{}{})
+	config := testProvider(t, "pki", "", fmt.Sprintf("role: client\ncommon_name: dev\ndir: %s", t.TempDir()))
+	provider, _ := NewPKIProvider(client, config)
+	if _, err := provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	cached, _ := NewPKIProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); cached.CredsLoaded() {
+		t.Fatal("expected the certificate close to its expiration not to be reused")
+	}
+	if err := cached.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	_, key, _ := cached.files()
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Fatalf("expected the key of the expired certificate to be removed - %v", err)
+	}
+}
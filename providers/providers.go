@@ -1,12 +1,19 @@
 package providers
 
 import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
 	"github.com/smorenodp/clusterprofile/config"
 )
 
 const (
-	layout    = "2006-01-02 15:04:05"
-	dataRegex = "%s=\"(?P<data>.*)\""
+	layout       = "2006-01-02 15:04:05"
+	dataRegex    = "%s=\"(?P<data>.*)\""
+	pluginPrefix = "clusterprofile-provider-"
 )
 
 type Provider interface {
@@ -17,19 +24,217 @@ type Provider interface {
 	CredsLoaded() bool
 }
 
-func NewProvider(client *VaultClient, config config.ProviderConfig) Provider {
-	switch config.Type {
-	case "consul":
-		return NewConsulProvider(client, config)
-	case "nomad":
-		return NewNomadProvider(client, config)
-	case "secret":
-		return NewSecretProvider(client, config)
-	case "text":
-		return NewTextProvider(client, config)
-	case "keepass":
-		return NewKeePassProvider(client, config)
-	default:
+// Remover is implemented by the providers that release their credentials
+// when the profile is removed. RemoveFiles deletes what they wrote locally,
+// even when the credentials expired, and RevokeCreds revokes the credentials
+// loaded from the cache.
+type Remover interface {
+	RemoveFiles() error
+	RevokeCreds() error
+}
+
+// OutputsConsumer is implemented by the providers that use the variables
+// generated by the providers run before them, like their tokens.
+type OutputsConsumer interface {
+	SetOutputs(outputs config.Outputs)
+}
+
+// VaultPath is a path of Vault used by a provider and the capability it
+// needs on it. Metadata marks the reads of the metadata of a KV v2 secret.
+type VaultPath struct {
+	Path       string
+	Capability string
+	Metadata   bool
+}
+
+func (p VaultPath) String() string {
+	if p.Metadata {
+		return p.Path + " (metadata)"
+	}
+	return p.Path
+}
+
+// VaultUser is implemented by the providers that read or write Vault paths,
+// so they can be checked without generating credentials.
+type VaultUser interface {
+	VaultPaths() []VaultPath
+}
+
+// Check is the result of checking a local prerequisite of a provider, with a
+// hint to fix it when it fails.
+type Check struct {
+	Name string
+	Err  error
+	Hint string
+}
+
+// Checker is implemented by the providers with local prerequisites, like
+// files, commands or agents.
+type Checker interface {
+	Check() []Check
+}
+
+// CacheState is the state of the cached credentials of a provider once
+// loaded: whether the credentials file had credentials of it and, when they
+// are reused, until when they are valid.
+type CacheState struct {
+	Stored  bool
+	Expires time.Time
+}
+
+// CacheInspector is implemented by the providers that report the state of
+// their cached credentials, so a load can be planned without running it.
+type CacheInspector interface {
+	CacheState() CacheState
+}
+
+// Factory creates a provider from its configuration.
+type Factory func(client *VaultClient, config config.ProviderConfig) (Provider, error)
+
+// Spec describes the methods a provider implements, the type of its config,
+// the config keys used and the fields required by each method. A required
+// field can be a list of alternatives separated by |, one of them is
+// required. A provider without methods doesn't use the method field and the
+// empty method applies to all of them. Validate checks the rules of the config that can't be
+// expressed with the required fields. Depends returns the providers whose
+// outputs it uses without referencing them, so they are run before it.
+type Spec struct {
+	Methods  []string
+	Config   interface{}
+	Keys     map[string][]string
+	Required map[string][]string
+	Validate func(p config.ProviderConfig, options interface{}) []config.FieldError
+	Depends  func(config.ProviderConfig) []string
+}
+
+type registration struct {
+	factory Factory
+	spec    *Spec
+}
+
+var registry = map[string]registration{}
+
+// Register makes a provider type available to the profiles.
+func Register(providerType string, factory Factory) {
+	r := registry[providerType]
+	r.factory = factory
+	registry[providerType] = r
+}
+
+// RegisterSpec describes a registered provider type, so its configuration can
+// be validated and included in the profiles schema.
+func RegisterSpec(providerType string, spec Spec) {
+	r := registry[providerType]
+	r.spec = &spec
+	registry[providerType] = r
+}
+
+func (s Spec) schemaSpec() config.SchemaSpec {
+	spec := config.SchemaSpec{Methods: s.Methods, Config: s.Config, Keys: s.Keys}
+	for method, required := range s.Required {
+		for _, key := range required {
+			if !strings.Contains(key, "|") {
+				continue
+			}
+			alternatives := []string{}
+			for _, alternative := range strings.Split(key, "|") {
+				alternatives = append(alternatives, strings.TrimPrefix(alternative, "config."))
+			}
+			if spec.Alternatives == nil {
+				spec.Alternatives = map[string][][]string{}
+			}
+			spec.Alternatives[method] = append(spec.Alternatives[method], alternatives)
+		}
+	}
+	return spec
+}
+
+// SchemaSpecs returns the configuration accepted by vault and by each provider
+// type to generate the profiles schema.
+func SchemaSpecs() (config.SchemaSpec, map[string]config.SchemaSpec) {
+	providers := map[string]config.SchemaSpec{}
+	for providerType, r := range registry {
+		if r.spec != nil {
+			providers[providerType] = r.spec.schemaSpec()
+		}
+	}
+	return vaultSpec.schemaSpec(), providers
+}
+
+// Dependencies returns the providers the provider uses implicitly, besides
+// the ones referenced in its configuration.
+func Dependencies(p config.ProviderConfig) []string {
+	if r, ok := registry[p.Type]; ok && r.spec != nil && r.spec.Depends != nil {
+		return r.spec.Depends(p)
+	}
+	return nil
+}
+
+// CheckProvider validates the provider type, method, config and the fields
+// required by the method.
+func CheckProvider(p config.ProviderConfig) []config.FieldError {
+	r, ok := registry[p.Type]
+	if !ok {
+		if _, err := exec.LookPath(pluginPrefix + p.Type); err == nil {
+			return nil
+		}
+		return []config.FieldError{{Key: "type", Msg: fmt.Sprintf("provider of type %s not implemented", p.Type)}}
+	}
+	if r.spec == nil {
 		return nil
 	}
+	options := reflect.New(reflect.TypeOf(r.spec.Config)).Interface()
+	if err := p.Decode(options); err != nil {
+		if unknown, ok := err.(config.UnknownKeyError); ok {
+			return []config.FieldError{{Key: "config." + unknown.Path, Msg: unknown.Message()}}
+		}
+		return []config.FieldError{{Key: "config", Msg: fmt.Sprintf("error decoding config - %s", err)}}
+	}
+	errs := checkMethod(p, options, p.Method, *r.spec)
+	if r.spec.Validate != nil {
+		errs = append(errs, r.spec.Validate(p, options)...)
+	}
+	return errs
+}
+
+// checkMethod validates the method and its required fields, the ones prefixed
+// with config. are looked up in options.
+func checkMethod(value interface{}, options interface{}, method string, s Spec) (errs []config.FieldError) {
+	required := s.Required[""]
+	if len(s.Methods) > 0 {
+		if method == "" {
+			return []config.FieldError{{Key: "method", Msg: "method is required"}}
+		}
+		if !contains(s.Methods, method) {
+			return []config.FieldError{{Key: "method", Msg: fmt.Sprintf("method %s not implemented, expected one of: %s", method, strings.Join(s.Methods, ", "))}}
+		}
+		required = append(append([]string{}, required...), s.Required[method]...)
+	}
+	for _, key := range required {
+		alternatives := strings.Split(key, "|")
+		empty := true
+		for _, alternative := range alternatives {
+			if options != nil && strings.HasPrefix(alternative, "config.") {
+				empty = empty && config.IsEmpty(options, strings.TrimPrefix(alternative, "config."))
+			} else {
+				empty = empty && config.IsEmpty(value, alternative)
+			}
+		}
+		if empty {
+			errs = append(errs, config.FieldError{Key: alternatives[0], Msg: fmt.Sprintf("%s is required", strings.Join(alternatives, " or "))})
+		}
+	}
+	return
+}
+
+// NewProvider creates a registered provider or, when the type is not
+// registered, a plugin provider if its binary is found in the PATH.
+func NewProvider(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+	if r, ok := registry[config.Type]; ok && r.factory != nil {
+		return r.factory(client, config)
+	}
+	if binary, err := exec.LookPath(pluginPrefix + config.Type); err == nil {
+		return NewExecProvider(client, config, binary)
+	}
+	return nil, nil
 }
@@ -0,0 +1,48 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+func TestCheckProviderAlternatives(t *testing.T) {
+	errs := CheckProvider(testProvider(t, "consul_kv", "", "json: true"))
+	if len(errs) != 1 || errs[0].Key != "config.keys" || errs[0].Msg != "config.keys or config.prefix is required" {
+		t.Fatalf("expected keys or prefix to be required, got %v", errs)
+	}
+	if errs = CheckProvider(testProvider(t, "consul_kv", "", "prefix: cluster/")); len(errs) != 0 {
+		t.Fatalf("expected no errors with prefix, got %v", errs)
+	}
+	vault := config.VaultConfig{Method: "token"}
+	if errs = CheckVault(vault); len(errs) != 1 || errs[0].Msg != "config.role or config.token is required" {
+		t.Fatalf("expected role or token to be required, got %v", errs)
+	}
+	vault.Config.Token = "static"
+	if errs = CheckVault(vault); len(errs) != 0 {
+		t.Fatalf("expected no errors with a token, got %v", errs)
+	}
+}
+
+func TestCheckProviderConsulVerify(t *testing.T) {
+	p := testProvider(t, "consul", "role", "role: dev\nverify: true")
+	p.Backend = "consul"
+	if errs := CheckProvider(p); len(errs) != 1 || errs[0].Key != "addr" {
+		t.Fatalf("expected addr to be required with verify, got %v", errs)
+	}
+	provider, err := NewConsulProvider(nil, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err == nil || err.Error() != "addr is required to verify the consul token" {
+		t.Fatalf("expected an error without addr, got %v", err)
+	}
+}
+
+func TestCheckProviderNomadVerify(t *testing.T) {
+	p := testProvider(t, "nomad", "role", "role: dev\nverify: true")
+	p.Backend = "nomad"
+	if errs := CheckProvider(p); len(errs) != 1 || errs[0].Key != "addr" {
+		t.Fatalf("expected addr to be required with verify, got %v", errs)
+	}
+}
@@ -3,6 +3,9 @@ package providers
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/smorenodp/clusterprofile/config"
 )
@@ -12,37 +15,117 @@ type SecretEnvVar struct {
 	value string
 }
 
+func init() {
+	Register("secret", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewSecretProvider(client, config)
+	})
+	RegisterSpec("secret", Spec{
+		Config:   SecretConfig{},
+		Required: map[string][]string{"": {"config.path"}},
+		Validate: func(_ config.ProviderConfig, options interface{}) []config.FieldError {
+			if o := options.(*SecretConfig); o.Recursive && !o.MapAll {
+				return []config.FieldError{{Key: "config.recursive", Msg: "config.map_all is required with recursive"}}
+			}
+			return nil
+		},
+	})
+}
+
+type SecretConfig struct {
+	SecretPath  string            `yaml:"path"`
+	SecretMap   map[string]string `yaml:"secret_map"`
+	Mount       string            `yaml:"mount"`
+	KVVersion   int               `yaml:"kv_version"`
+	Version     int               `yaml:"version"`
+	MetadataMap map[string]string `yaml:"metadata_map"`
+	MapAll      bool              `yaml:"map_all"`
+	Prefix      string            `yaml:"prefix"`
+	Case        string            `yaml:"case"`
+	Recursive   bool              `yaml:"recursive"`
+}
+
 type SecretProvider struct {
 	client     *VaultClient
 	config     config.ProviderConfig
+	options    SecretConfig
 	mapEnvVars map[string]SecretEnvVar
 	load       bool
 }
 
 func (p *SecretProvider) generateMap() {
-	mapEnvVars := map[string]SecretEnvVar{}
-	for _, envValue := range p.config.Config.SecretMap {
-		mapEnvVars[envValue] = SecretEnvVar{regex: regexp.MustCompile(fmt.Sprintf(dataRegex, envValue))}
+	p.mapEnvVars = map[string]SecretEnvVar{}
+	if !p.options.MapAll {
+		for _, envValue := range p.options.SecretMap {
+			p.addEnvVar(envValue)
+		}
+	}
+	for _, envValue := range p.options.MetadataMap {
+		p.addEnvVar(envValue)
+	}
+}
+
+func (p *SecretProvider) addEnvVar(envName string) {
+	if _, ok := p.mapEnvVars[envName]; !ok {
+		p.mapEnvVars[envName] = SecretEnvVar{regex: regexp.MustCompile("^" + fmt.Sprintf(dataRegex, regexp.QuoteMeta(envName)))}
+	}
+}
+
+// envName returns the variable of a key when all the keys are mapped, keys
+// inside subfolders are prefixed with their relative path.
+func (p *SecretProvider) envName(folder string, key string) string {
+	if envName, ok := p.options.SecretMap[key]; ok && folder == "" {
+		return envName
+	}
+	if folder != "" {
+		key = strings.Trim(folder, "/") + "_" + key
 	}
-	p.mapEnvVars = mapEnvVars
+	key = regexp.MustCompile(markerRegex).ReplaceAllString(key, "_")
+	switch p.options.Case {
+	case "lower":
+		key = strings.ToLower(key)
+	case "none":
+	default:
+		key = strings.ToUpper(key)
+	}
+	return p.options.Prefix + key
 }
 
-func NewSecretProvider(client *VaultClient, config config.ProviderConfig) *SecretProvider {
+func NewSecretProvider(client *VaultClient, config config.ProviderConfig) (*SecretProvider, error) {
 	p := SecretProvider{client: client, config: config}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
 	p.generateMap()
-	return &p
+	return &p, nil
 }
 
+// LoadProfileCreds loads the mapped variables from the credentials file. When
+// all the keys are mapped the variables are the ones stored in the KEYS marker
+// the last time the secret was read.
 func (p *SecretProvider) LoadProfileCreds(info []string) {
+	if p.options.MapAll {
+		keys := parseCreds(info)[markerName(p.config.ID(), "KEYS")]
+		if keys == "" {
+			p.load = false
+			return
+		}
+		for _, envName := range strings.Split(keys, ",") {
+			p.addEnvVar(envName)
+		}
+	}
 	envNames := []string{}
-	for key, _ := range p.mapEnvVars {
+	for key := range p.mapEnvVars {
 		envNames = append(envNames, key)
 	}
 	for _, i := range info {
-		for envIndex, envName := range envNames {
+		for envIndex := len(envNames) - 1; envIndex >= 0; envIndex-- {
+			envName := envNames[envIndex]
 			envVar := p.mapEnvVars[envName]
 			if matches := envVar.regex.FindStringSubmatch(i); matches != nil {
 				envVar.value = matches[1]
+				if value, err := strconv.Unquote(`"` + matches[1] + `"`); err == nil {
+					envVar.value = value
+				}
 				p.mapEnvVars[envName] = envVar
 				envNames = remove(envNames, envIndex)
 			}
@@ -52,17 +135,33 @@ func (p *SecretProvider) LoadProfileCreds(info []string) {
 }
 
 func (p *SecretProvider) GenerateCreds() (string, error) {
-	secret, err := p.client.Logical().Read(p.config.Config.SecretPath)
+	path := p.client.kvPath(p.options.SecretPath, p.options.Mount, p.options.KVVersion)
+	p.generateMap()
+	if p.options.MapAll && p.options.Recursive {
+		return "", p.generateTree(path)
+	}
+	data, metadata, err := p.client.readKV(path, p.options.Version, len(p.options.MetadataMap) > 0)
 	if err != nil {
 		return "", err
 	}
-	if secret != nil {
+	if data != nil {
 		envVars2Load := len(p.mapEnvVars)
-		for key, value := range secret.Data {
-			if envName, ok := p.config.Config.SecretMap[key]; ok {
-				envVar := p.mapEnvVars[envName]
-				envVar.value = value.(string)
-				p.mapEnvVars[envName] = envVar
+		if p.options.MapAll {
+			for key, value := range data {
+				p.addEnvVar(p.envName("", key))
+				p.setValue(p.envName("", key), stringify(value))
+			}
+		} else {
+			for key, envName := range p.options.SecretMap {
+				if value, ok := data[key]; ok {
+					p.setValue(envName, stringify(value))
+					envVars2Load--
+				}
+			}
+		}
+		for key, envName := range p.options.MetadataMap {
+			if value, ok := metadata[key]; ok {
+				p.setValue(envName, stringify(value))
 				envVars2Load--
 			}
 		}
@@ -71,6 +170,58 @@ func (p *SecretProvider) GenerateCreds() (string, error) {
 	return "", nil
 }
 
+func (p *SecretProvider) VaultPaths() []VaultPath {
+	path, mount := strings.Trim(p.options.SecretPath, "/"), strings.Trim(p.options.Mount, "/")
+	if mount != "" && !strings.HasPrefix(path, mount+"/") {
+		path = mount + "/" + path
+	}
+	if p.options.MapAll && p.options.Recursive {
+		return []VaultPath{{Path: path, Capability: "list"}}
+	}
+	paths := []VaultPath{{Path: path, Capability: "read"}}
+	if len(p.options.MetadataMap) > 0 {
+		paths = append(paths, VaultPath{Path: path, Capability: "read", Metadata: true})
+	}
+	return paths
+}
+
+// generateTree maps all the keys of the secrets under the folder, walking its
+// subfolders.
+func (p *SecretProvider) generateTree(root kvPath) error {
+	folders := []string{""}
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+		entries, err := p.client.listKV(kvPath{mount: root.mount, path: strings.TrimSuffix(root.path, "/") + "/" + folder, version: root.version})
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry, "/") {
+				folders = append(folders, folder+entry)
+				continue
+			}
+			secret := kvPath{mount: root.mount, path: strings.TrimSuffix(root.path, "/") + "/" + folder + entry, version: root.version}
+			data, _, err := p.client.readKV(secret, 0, false)
+			if err != nil {
+				return err
+			}
+			for key, value := range data {
+				p.addEnvVar(p.envName(folder+entry, key))
+				p.setValue(p.envName(folder+entry, key), stringify(value))
+			}
+		}
+	}
+	p.load = len(p.mapEnvVars) > 0
+	return nil
+}
+
+func (p *SecretProvider) setValue(envName string, value string) {
+	envVar := p.mapEnvVars[envName]
+	envVar.value = value
+	p.mapEnvVars[envName] = envVar
+}
+
 func (p *SecretProvider) ExportCreds() (export []string) {
 	for envName, envValue := range p.mapEnvVars {
 		if envValue.value != "" {
@@ -85,11 +236,23 @@ func (p *SecretProvider) CredsLoaded() bool {
 	return p.load
 }
 
+// CacheState reports the variables of the secret as stored when all of them
+// were loaded, they don't expire.
+func (p *SecretProvider) CacheState() CacheState {
+	return CacheState{Stored: p.load}
+}
+
 func (p *SecretProvider) ProfileCreds() (creds []string) {
+	keys := []string{}
 	for envName, envValue := range p.mapEnvVars {
 		if envValue.value != "" {
 			creds = append(creds, fmt.Sprintf("%s=%q", envName, envValue.value))
+			keys = append(keys, envName)
 		}
 	}
+	if p.options.MapAll && len(keys) > 0 {
+		sort.Strings(keys)
+		creds = append(creds, fmt.Sprintf("%s=%q", markerName(p.config.ID(), "KEYS"), strings.Join(keys, ",")))
+	}
 	return
 }
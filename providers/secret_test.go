@@ -0,0 +1,189 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// kvV2Handlers answers a KV v2 mount secret/ with the secret app, in
+// version 3 of 3.
+func kvV2Handlers() map[string]interface{} {
+	mount := map[string]interface{}{
+		"data": map[string]interface{}{"path": "secret/", "type": "kv", "options": map[string]interface{}{"version": "2"}},
+	}
+	return map[string]interface{}{
+		"/v1/sys/internal/ui/mounts/secret/app":      mount,
+		"/v1/sys/internal/ui/mounts/secret/data/app": mount,
+		"/v1/secret/data/app": func(r *http.Request) interface{} {
+			version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+			if version == 0 {
+				version = 3
+			}
+			return map[string]interface{}{"data": map[string]interface{}{
+				"data": map[string]interface{}{"password": fmt.Sprintf("v%d", version), "port": 5432, "tls": true, "hosts": []string{"a", "b"}},
+				"metadata": map[string]interface{}{
+					"version":         version,
+					"created_time":    "2024-05-02T10:00:00Z",
+					"custom_metadata": map[string]interface{}{"owner": "team"},
+				},
+			}}
+		},
+		"/v1/secret/metadata/app": map[string]interface{}{"data": map[string]interface{}{
+			"created_time":         "2024-05-01T10:00:00Z",
+			"updated_time":         "2024-05-02T10:00:00Z",
+			"current_version":      3,
+			"oldest_version":       1,
+			"max_versions":         0,
+			"cas_required":         false,
+			"delete_version_after": "0s",
+			"custom_metadata":      map[string]interface{}{"owner": "team"},
+			"versions":             map[string]interface{}{},
+		}},
+	}
+}
+
+// exported returns the exported variables sorted, without the export.
+func exported(p Provider) string {
+	export := p.ExportCreds()
+	for i := range export {
+		export[i] = strings.TrimPrefix(export[i], "export ")
+	}
+	sort.Strings(export)
+	return strings.Join(export, ",")
+}
+
+func TestKVPathDetectsV2(t *testing.T) {
+	client := testVault(t, kvV2Handlers())
+	for _, path := range []string{"secret/app", "secret/data/app", "/secret/app/"} {
+		p := client.kvPath(path, "", 0)
+		if p.mount != "secret" || p.path != "app" || p.version != 2 {
+			t.Errorf("unexpected kv path of %s: %+v", path, p)
+		}
+	}
+	// The configured mount and version are used without reading the tuning.
+	if p := client.kvPath("kv/app", "kv", 1); p.mount != "kv" || p.path != "app" || p.version != 1 {
+		t.Errorf("unexpected configured kv path %+v", p)
+	}
+}
+
+func TestSecretProviderKVv2(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{"stringify", "path: secret/app\nsecret_map: {password: PASSWORD, port: PORT, tls: TLS, hosts: HOSTS}",
+			`HOSTS="[\"a\",\"b\"]",PASSWORD="v3",PORT="5432",TLS="true"`},
+		{"version pinned", "path: secret/app\nversion: 2\nsecret_map: {password: PASSWORD}\nmetadata_map: {version: VERSION}",
+			`PASSWORD="v2",VERSION="2"`},
+		{"version metadata", "path: secret/app\nmetadata_map: {version: VERSION, created_time: CREATED}",
+			`CREATED="2024-05-02T10:00:00Z",VERSION="3"`},
+		{"secret metadata", "path: secret/app\nmetadata_map: {updated_time: UPDATED, current_version: CURRENT}",
+			`CURRENT="3",UPDATED="2024-05-02T10:00:00Z"`},
+		{"oldest version only", "path: secret/app\nmetadata_map: {oldest_version: OLDEST}",
+			`OLDEST="1"`},
+		{"custom metadata", "path: secret/app\nmetadata_map: {custom_metadata.owner: OWNER}",
+			`OWNER="team"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := testVault(t, kvV2Handlers())
+			provider, err := NewSecretProvider(client, testProvider(t, "secret", "", test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err != nil {
+				t.Fatal(err)
+			}
+			if !provider.CredsLoaded() {
+				t.Fatalf("expected all the variables to be loaded, got %s", exported(provider))
+			}
+			if got := exported(provider); got != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestSecretProviderMetadataPath(t *testing.T) {
+	provider, err := NewSecretProvider(nil, testProvider(t, "secret", "", "path: secret/app\nmetadata_map: {oldest_version: OLDEST}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := provider.VaultPaths()
+	if len(paths) != 2 || !paths[1].Metadata {
+		t.Fatalf("expected the data and metadata paths, got %v", paths)
+	}
+}
+
+// kvTreeHandlers answers a KV v2 mount secret/ with the folder tree holding
+// the secret db and the subfolder nested with the secret api.
+func kvTreeHandlers() map[string]interface{} {
+	list := func(keys ...string) func(*http.Request) interface{} {
+		return func(r *http.Request) interface{} {
+			if r.URL.Query().Get("list") != "true" {
+				return http.StatusMethodNotAllowed
+			}
+			return map[string]interface{}{"data": map[string]interface{}{"keys": keys}}
+		}
+	}
+	secret := func(data map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 1}}}
+	}
+	return map[string]interface{}{
+		"/v1/secret/metadata/tree":        list("db", "nested/"),
+		"/v1/secret/metadata/tree/nested": list("api"),
+		"/v1/secret/data/tree/db":         secret(map[string]interface{}{"password": "db-pass", "Api-Key": "db-key"}),
+		"/v1/secret/data/tree/nested/api": secret(map[string]interface{}{"token": "api-token"}),
+	}
+}
+
+func TestSecretProviderMapAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{"upper case", "path: tree/db", `API_KEY="db-key",PASSWORD="db-pass"`},
+		{"prefix and secret_map", "path: tree/db\nprefix: APP_\nsecret_map: {password: DB_PASSWORD}", `APP_API_KEY="db-key",DB_PASSWORD="db-pass"`},
+		{"lower case", "path: tree/db\ncase: lower", `api_key="db-key",password="db-pass"`},
+		{"no case", "path: tree/db\ncase: none", `Api_Key="db-key",password="db-pass"`},
+		{"recursive", "path: tree\nrecursive: true\nprefix: APP_",
+			`APP_DB_API_KEY="db-key",APP_DB_PASSWORD="db-pass",APP_NESTED_API_TOKEN="api-token"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testProvider(t, "secret", "", "mount: secret\nkv_version: 2\nmap_all: true\n"+test.config)
+			provider, err := NewSecretProvider(testVault(t, kvTreeHandlers()), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.GenerateCreds(); err != nil {
+				t.Fatal(err)
+			}
+			if got := exported(provider); got != test.expected || !provider.CredsLoaded() {
+				t.Fatalf("expected %s, got %s", test.expected, got)
+			}
+
+			// The keys stored are loaded again without reading the secret.
+			cached, _ := NewSecretProvider(nil, config)
+			if cached.LoadProfileCreds(provider.ProfileCreds()); !cached.CredsLoaded() || exported(cached) != test.expected {
+				t.Fatalf("expected the cached %s, got %s", test.expected, exported(cached))
+			}
+		})
+	}
+}
+
+func TestCheckProviderSecretRecursive(t *testing.T) {
+	errs := CheckProvider(testProvider(t, "secret", "", "path: tree\nrecursive: true"))
+	if len(errs) != 1 || errs[0].Key != "config.recursive" {
+		t.Fatalf("expected map_all to be required with recursive, got %v", errs)
+	}
+	if errs = CheckProvider(testProvider(t, "secret", "", "path: tree\nrecursive: true\nmap_all: true")); len(errs) != 0 {
+		t.Fatalf("expected no errors with map_all, got %v", errs)
+	}
+}
@@ -0,0 +1,320 @@
+package providers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/smorenodp/clusterprofile/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	sshDefaultBackend = "ssh"
+	sshSignedKey      = "signed_key"
+	sshEnvKeyVar      = "SSH_KEY_PATH"
+	sshEnvCertVar     = "SSH_CERT_PATH"
+	sshAuthSockVar    = "SSH_AUTH_SOCK"
+	sshKeyFolder      = ".ssh"
+)
+
+func init() {
+	Register("ssh", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewSSHProvider(client, config)
+	})
+	RegisterSpec("ssh", Spec{
+		Config:   SSHConfig{},
+		Required: map[string][]string{"": {"config.role"}},
+	})
+}
+
+type SSHConfig struct {
+	Role       string            `yaml:"role"`
+	Principals []string          `yaml:"principals"`
+	TTL        string            `yaml:"ttl"`
+	Key        string            `yaml:"key"`
+	Extensions map[string]string `yaml:"extensions"`
+	Agent      bool              `yaml:"agent"`
+}
+
+// SSHProvider signs a public key with the SSH secrets engine, generating the
+// keypair the first time, and writes the certificate next to the key.
+type SSHProvider struct {
+	client  *VaultClient
+	config  config.ProviderConfig
+	options SSHConfig
+	creds   *credSet
+}
+
+func NewSSHProvider(client *VaultClient, config config.ProviderConfig) (*SSHProvider, error) {
+	p := &SSHProvider{client: client, config: config, creds: newCredSet(config.ID())}
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	if p.config.Backend == "" {
+		p.config.Backend = sshDefaultBackend
+	}
+	if p.options.Key == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		p.options.Key = filepath.Join(home, sshKeyFolder, fmt.Sprintf("clusterprofile-%s-%s", config.Profile, config.ID()))
+	}
+	return p, nil
+}
+
+func (p *SSHProvider) certFile() string {
+	return p.options.Key + "-cert.pub"
+}
+
+func (p *SSHProvider) LoadProfileCreds(info []string) {
+	if !p.creds.load(info) {
+		return
+	}
+	// The certificate could have been replaced since it was stored, its
+	// expiration is the one that counts.
+	cert, err := p.readCert()
+	if err != nil || !time.Now().Before(certExpiration(cert)) {
+		p.creds.reset()
+		return
+	}
+	p.creds.TTL = certExpiration(cert)
+	// The agent could have been restarted since the key was added to it.
+	if p.options.Agent && !p.inAgent(cert) {
+		key, err := p.readKey()
+		if err != nil || p.addToAgent(key, cert) != nil {
+			p.creds.reset()
+		}
+	}
+}
+
+func (p *SSHProvider) GenerateCreds() (string, error) {
+	key, err := p.loadKey()
+	if err != nil {
+		return "", err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", err
+	}
+	data := map[string]interface{}{
+		"public_key": string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		"cert_type":  "user",
+	}
+	if len(p.options.Principals) > 0 {
+		data["valid_principals"] = strings.Join(p.options.Principals, ",")
+	}
+	if p.options.TTL != "" {
+		data["ttl"] = p.options.TTL
+	}
+	if len(p.options.Extensions) > 0 {
+		data["extensions"] = p.options.Extensions
+	}
+	path := fmt.Sprintf("%s/sign/%s", p.config.Backend, p.options.Role)
+	secret, err := p.client.Logical().Write(path, data)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no certificate returned by %s", path)
+	}
+	signed, _ := secret.Data[sshSignedKey].(string)
+	if err = os.WriteFile(p.certFile(), []byte(signed), 0644); err != nil {
+		return "", err
+	}
+	cert, err := p.readCert()
+	if err != nil {
+		return "", err
+	}
+	if p.options.Agent {
+		if err = p.addToAgent(key, cert); err != nil {
+			return "", err
+		}
+	}
+
+	p.creds.reset()
+	p.creds.set(sshEnvKeyVar, p.options.Key)
+	p.creds.set(sshEnvCertVar, p.certFile())
+	p.creds.TTL = certExpiration(cert)
+	return signed, nil
+}
+
+// readKey reads the private key, ed25519 keys are returned as values like
+// the generated ones.
+func (p *SSHProvider) readKey() (interface{}, error) {
+	content, err := os.ReadFile(p.options.Key)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key %s - %s", p.options.Key, err)
+	}
+	if private, ok := key.(*ed25519.PrivateKey); ok {
+		return *private, nil
+	}
+	return key, nil
+}
+
+// loadKey reads the private key or generates an ed25519 keypair when it
+// doesn't exist.
+func (p *SSHProvider) loadKey() (interface{}, error) {
+	key, err := p.readKey()
+	if !os.IsNotExist(err) {
+		return key, err
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(private, "clusterprofile")
+	if err != nil {
+		return nil, err
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(p.options.Key), 0700); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(p.options.Key, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(p.options.Key+".pub", ssh.MarshalAuthorizedKey(sshPublic), 0644); err != nil {
+		return nil, err
+	}
+	return private, nil
+}
+
+func (p *SSHProvider) readCert() (*ssh.Certificate, error) {
+	content, err := os.ReadFile(p.certFile())
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", p.certFile())
+	}
+	return cert, nil
+}
+
+func certExpiration(cert *ssh.Certificate) time.Time {
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		return time.Now().AddDate(100, 0, 0)
+	}
+	return time.Unix(int64(cert.ValidBefore), 0)
+}
+
+func (p *SSHProvider) agentClient() (agent.ExtendedAgent, net.Conn, error) {
+	sock := os.Getenv(sshAuthSockVar)
+	if sock == "" {
+		return nil, nil, fmt.Errorf("%s is not set, no ssh-agent to add the key", sshAuthSockVar)
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// inAgent reports if the certificate is in the ssh-agent.
+func (p *SSHProvider) inAgent(cert *ssh.Certificate) bool {
+	client, conn, err := p.agentClient()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	keys, err := client.List()
+	if err != nil {
+		return false
+	}
+	for _, key := range keys {
+		if bytes.Equal(key.Blob, cert.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// addToAgent adds the key and its certificate to the ssh-agent until the
+// certificate expires.
+func (p *SSHProvider) addToAgent(key interface{}, cert *ssh.Certificate) error {
+	client, conn, err := p.agentClient()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	lifetime := time.Until(certExpiration(cert))
+	return client.Add(agent.AddedKey{PrivateKey: key, Certificate: cert, LifetimeSecs: uint32(lifetime.Seconds()),
+		Comment: fmt.Sprintf("clusterprofile %s %s", p.config.Profile, p.config.ID())})
+}
+
+func (p *SSHProvider) VaultPaths() []VaultPath {
+	return []VaultPath{{Path: fmt.Sprintf("%s/sign/%s", p.config.Backend, p.options.Role), Capability: "update"}}
+}
+
+// Check checks the ssh-agent the key is added to.
+func (p *SSHProvider) Check() []Check {
+	if !p.options.Agent {
+		return nil
+	}
+	check := Check{Name: "ssh-agent", Hint: fmt.Sprintf("start an ssh-agent and export %s, or disable agent", sshAuthSockVar)}
+	if _, conn, err := p.agentClient(); err != nil {
+		check.Err = err
+	} else {
+		conn.Close()
+	}
+	return []Check{check}
+}
+
+// RemoveFiles deletes the certificate, and removes it from the ssh-agent,
+// keeping the keypair to be signed again.
+func (p *SSHProvider) RemoveFiles() error {
+	if p.options.Agent {
+		if cert, err := p.readCert(); err == nil {
+			if client, conn, err := p.agentClient(); err == nil {
+				defer conn.Close()
+				client.Remove(cert)
+			}
+		}
+	}
+	if err := os.Remove(p.certFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RevokeCreds does nothing, the certificates can't be revoked.
+func (p *SSHProvider) RevokeCreds() error {
+	return nil
+}
+
+func (p *SSHProvider) ExportCreds() []string {
+	return p.creds.export()
+}
+
+func (p *SSHProvider) CredsLoaded() bool {
+	return p.creds.loaded()
+}
+
+func (p *SSHProvider) CacheState() CacheState {
+	return p.creds.state()
+}
+
+func (p *SSHProvider) ProfileCreds() []string {
+	return p.creds.profile()
+}
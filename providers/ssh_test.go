@@ -0,0 +1,198 @@
+package providers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshVault answers the ssh role dev signing the public keys with a test CA
+// for the validity, and records the keys signed.
+func sshVault(t *testing.T, validity time.Duration, signed *[]string) *VaultClient {
+	t.Helper()
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testVault(t, map[string]interface{}{
+		"/v1/ssh/sign/dev": func(r *http.Request) interface{} {
+			var request struct {
+				PublicKey string `json:"public_key"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			*signed = append(*signed, request.PublicKey)
+			public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(request.PublicKey))
+			if err != nil {
+				return http.StatusBadRequest
+			}
+			cert := &ssh.Certificate{Key: public, CertType: ssh.UserCert, ValidPrincipals: []string{"dev"},
+				ValidAfter: uint64(time.Now().Add(-time.Minute).Unix()), ValidBefore: ssh.CertTimeInfinity}
+			if validity != 0 {
+				cert.ValidBefore = uint64(time.Now().Add(validity).Unix())
+			}
+			if err = cert.SignCert(rand.Reader, ca); err != nil {
+				return http.StatusInternalServerError
+			}
+			return map[string]interface{}{"data": map[string]interface{}{"signed_key": string(ssh.MarshalAuthorizedKey(cert))}}
+		},
+	})
+}
+
+// testAgent serves an ssh-agent keyring in SSH_AUTH_SOCK.
+func testAgent(t *testing.T, keyring agent.Agent) {
+	t.Helper()
+	// The socket path must be short, so it's not under the test folder.
+	folder, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(folder) })
+	sock := filepath.Join(folder, "sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Setenv(sshAuthSockVar, sock)
+}
+
+func TestSSHProviderKeyAndCert(t *testing.T) {
+	signed := []string{}
+	client := sshVault(t, time.Hour, &signed)
+	key := filepath.Join(t.TempDir(), "keys", "dev")
+	config := testProvider(t, "ssh", "", fmt.Sprintf("role: dev\nkey: %s", key))
+	provider, err := NewSSHProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(key); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the private key with mode 0600 - %v", err)
+	}
+	if _, err = os.Stat(key + ".pub"); err != nil {
+		t.Fatalf("expected the public key - %s", err)
+	}
+	expected := fmt.Sprintf(`SSH_CERT_PATH="%s-cert.pub",SSH_KEY_PATH="%s"`, key, key)
+	if got := exported(provider); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	if until := time.Until(provider.CacheState().Expires); until < 59*time.Minute || until > time.Hour {
+		t.Fatalf("expected the certificate expiration, got %s", provider.CacheState().Expires)
+	}
+
+	// The existing key is signed again as it is.
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != 2 || signed[0] != signed[1] {
+		t.Fatalf("expected the same key to be signed twice, got %v", signed)
+	}
+	readKey, err := provider.readKey()
+	if _, ok := readKey.(ed25519.PrivateKey); !ok || err != nil {
+		t.Fatalf("expected an ed25519 key, got %T - %v", readKey, err)
+	}
+
+	cached, _ := NewSSHProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); !cached.CredsLoaded() {
+		t.Fatal("expected the cached certificate to be reused")
+	}
+
+	if err = cached.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(key + "-cert.pub"); !os.IsNotExist(err) {
+		t.Fatalf("expected the certificate to be removed - %v", err)
+	}
+	if _, err = os.Stat(key); err != nil {
+		t.Fatalf("expected the key to be kept - %s", err)
+	}
+	cached, _ = NewSSHProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); cached.CredsLoaded() {
+		t.Fatal("expected the credentials to be generated again without the certificate")
+	}
+}
+
+func TestSSHProviderExpiredCert(t *testing.T) {
+	client := sshVault(t, -time.Minute, &[]string{})
+	config := testProvider(t, "ssh", "", fmt.Sprintf("role: dev\nkey: %s", filepath.Join(t.TempDir(), "dev")))
+	provider, _ := NewSSHProvider(client, config)
+	if _, err := provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	cached, _ := NewSSHProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); cached.CredsLoaded() {
+		t.Fatal("expected the expired certificate not to be reused")
+	}
+}
+
+func TestCertExpiration(t *testing.T) {
+	if expiration := certExpiration(&ssh.Certificate{ValidBefore: ssh.CertTimeInfinity}); expiration.Before(time.Now().AddDate(99, 0, 0)) {
+		t.Fatalf("expected a certificate without expiration to last, got %s", expiration)
+	}
+	validBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if expiration := certExpiration(&ssh.Certificate{ValidBefore: uint64(validBefore.Unix())}); !expiration.Equal(validBefore) {
+		t.Fatalf("expected %s, got %s", validBefore, expiration)
+	}
+}
+
+func TestSSHProviderAgent(t *testing.T) {
+	keyring := agent.NewKeyring()
+	testAgent(t, keyring)
+	client := sshVault(t, 0, &[]string{})
+	config := testProvider(t, "ssh", "", fmt.Sprintf("role: dev\nagent: true\nkey: %s", filepath.Join(t.TempDir(), "dev")))
+	provider, err := NewSSHProvider(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = provider.GenerateCreds(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := keyring.List(); len(keys) != 1 {
+		t.Fatalf("expected the certificate in the agent, got %v", keys)
+	}
+
+	// The agent restarted without the key, it's added again with the cache.
+	keyring.RemoveAll()
+	cached, _ := NewSSHProvider(client, config)
+	if cached.LoadProfileCreds(provider.ProfileCreds()); !cached.CredsLoaded() {
+		t.Fatal("expected the cached certificate to be reused")
+	}
+	if keys, _ := keyring.List(); len(keys) != 1 {
+		t.Fatalf("expected the certificate to be added again to the agent, got %v", keys)
+	}
+
+	if err = cached.RemoveFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := keyring.List(); len(keys) != 0 {
+		t.Fatalf("expected the certificate to be removed from the agent, got %v", keys)
+	}
+}
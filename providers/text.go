@@ -14,17 +14,38 @@ const (
 	exportRegex = "(?P<data>[^=]*)[\\ ]*=[\\ ]*(?P<data>.*)"
 )
 
+func init() {
+	Register("text", func(client *VaultClient, config config.ProviderConfig) (Provider, error) {
+		return NewTextProvider(client, config)
+	})
+	RegisterSpec("text", Spec{
+		Methods:  []string{"file", "data"},
+		Config:   TextConfig{},
+		Keys:     map[string][]string{"file": {"file"}, "data": {"data"}},
+		Required: map[string][]string{"file": {"config.file"}, "data": {"config.data"}},
+	})
+}
+
+type TextConfig struct {
+	File string `yaml:"file"`
+	Data string `yaml:"data"`
+}
+
 type TextProvider struct {
 	client     *VaultClient
 	config     config.ProviderConfig
+	options    TextConfig
 	load       bool
 	mapEnvVars map[string]string
 	regex      *regexp.Regexp
 }
 
-func NewTextProvider(client *VaultClient, config config.ProviderConfig) *TextProvider {
+func NewTextProvider(client *VaultClient, config config.ProviderConfig) (*TextProvider, error) {
 	p := TextProvider{client: client, config: config, regex: regexp.MustCompile(exportRegex), mapEnvVars: make(map[string]string)}
-	return &p
+	if err := config.Decode(&p.options); err != nil {
+		return nil, err
+	}
+	return &p, nil
 }
 
 func (p *TextProvider) LoadProfileCreds(info []string) {
@@ -32,7 +53,7 @@ func (p *TextProvider) LoadProfileCreds(info []string) {
 }
 
 func (p *TextProvider) generateFromFile() (string, error) {
-	f, err := os.Open(p.config.Config.File)
+	f, err := os.Open(p.options.File)
 	if err != nil {
 		return "", err
 	}
@@ -50,7 +71,7 @@ func (p *TextProvider) generateFromFile() (string, error) {
 }
 
 func (p *TextProvider) generateFromData() (string, error) {
-	r := bufio.NewReader(strings.NewReader(p.config.Config.Data))
+	r := bufio.NewReader(strings.NewReader(p.options.Data))
 	for line, _, _ := r.ReadLine(); line != nil; line, _, _ = r.ReadLine() {
 		stringLine := string(line)
 		if matches := p.regex.FindStringSubmatch(stringLine); matches != nil {
@@ -62,15 +83,17 @@ func (p *TextProvider) generateFromData() (string, error) {
 	return "", nil
 }
 
-func (p *TextProvider) GenerateCreds() (string, error) {
+func (p *TextProvider) GenerateCreds() (token string, err error) {
 	switch p.config.Method {
 	case "file":
-		return p.generateFromFile()
+		token, err = p.generateFromFile()
 	case "data":
-		return p.generateFromData()
+		token, err = p.generateFromData()
 	default:
 		return "", fmt.Errorf("method %s not implemented yet", p.config.Method)
 	}
+	p.load = (err == nil)
+	return
 }
 
 func (p *TextProvider) ExportCreds() (export []string) {
@@ -84,6 +107,12 @@ func (p *TextProvider) CredsLoaded() bool {
 	return p.load
 }
 
+// CacheState reports the variables as never stored, they are read on every
+// load.
+func (p *TextProvider) CacheState() CacheState {
+	return CacheState{}
+}
+
 func (p *TextProvider) ProfileCreds() (creds []string) {
 	for envName, envValue := range p.mapEnvVars {
 		creds = append(creds, fmt.Sprintf("export %s=%q", envName, envValue))
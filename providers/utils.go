@@ -1,5 +1,10 @@
 package providers
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 func remove(s []string, i int) []string {
 	s[i] = s[len(s)-1]
 	return s[:len(s)-1]
@@ -13,3 +18,22 @@ func contains(s []string, v string) bool {
 	}
 	return false
 }
+
+// stringify returns the value as the string to export, JSON encoding the
+// values that are not scalars.
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number, bool, int, int64, float64:
+		return fmt.Sprint(v)
+	default:
+		content, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(content)
+	}
+}
@@ -2,10 +2,13 @@ package providers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
+	"strings"
 	"time"
 
 	vault "github.com/hashicorp/vault/api"
@@ -13,15 +16,49 @@ import (
 )
 
 const (
-	vaultEnvTokenVar = "VAULT_TOKEN"
-	vaultEnvTTLVar   = "VAULT_TTL"
-	vaultEnvAddrVar  = "VAULT_ADDR"
+	scopedTokenTTL           = "15m"
+	scopedTokenUses          = 10
+	vaultEnvTokenVar         = "VAULT_TOKEN"
+	vaultEnvTTLVar           = "VAULT_TTL"
+	vaultEnvAddrVar          = "VAULT_ADDR"
+	vaultEnvNamespaceVar     = "VAULT_NAMESPACE"
+	vaultEnvCACertVar        = "VAULT_CACERT"
+	vaultEnvCAPathVar        = "VAULT_CAPATH"
+	vaultEnvClientCertVar    = "VAULT_CLIENT_CERT"
+	vaultEnvClientKeyVar     = "VAULT_CLIENT_KEY"
+	vaultEnvTLSServerNameVar = "VAULT_TLS_SERVER_NAME"
+	vaultEnvSkipVerifyVar    = "VAULT_SKIP_VERIFY"
+	vaultEnvClientTimeoutVar = "VAULT_CLIENT_TIMEOUT"
 )
 
+var vaultSpec = Spec{
+	Methods: []string{"oidc", "token", "approle", "jwt", "unwrap"},
+	Config:  config.VaultAuthConfig{},
+	Keys: map[string][]string{
+		"token":   {"role", "token", "policies"},
+		"approle": {"path", "role_id", "mount"},
+		"jwt":     {"path", "role", "mount"},
+		"unwrap":  {"path"},
+	},
+	Required: map[string][]string{
+		"approle": {"config.path", "pivoting_profile"},
+		"jwt":     {"config.path", "config.role", "pivoting_profile"},
+		"unwrap":  {"config.path", "pivoting_profile"},
+		"token":   {"config.role|config.token"},
+	},
+}
+
+// CheckVault validates the login method of the vault configuration and the
+// fields required by it.
+func CheckVault(vault config.VaultConfig) []config.FieldError {
+	return checkMethod(vault, nil, vault.Method, vaultSpec)
+}
+
 type VaultClient struct {
 	config config.VaultConfig
 	TTL    time.Time
 	Pivot  *VaultClient
+	stored bool
 	*vault.Client
 }
 
@@ -39,6 +76,7 @@ func (c *VaultClient) LoadProfileCreds(info []string) bool {
 		}
 	}
 
+	c.stored = token != ""
 	if time.Now().Before(ttl) {
 		c.SetToken(token)
 		c.TTL = ttl
@@ -47,25 +85,51 @@ func (c *VaultClient) LoadProfileCreds(info []string) bool {
 	return loaded
 }
 
+// StaticToken uses the token configured in the profile, the only one that is
+// available without a login, reporting if there is one.
+func (c *VaultClient) StaticToken() bool {
+	if c.config.Method != "token" || c.config.Config.Role != "" || c.config.Config.Token == "" {
+		return false
+	}
+	c.SetToken(c.config.Config.Token)
+	return true
+}
+
 func NewVaultClient(config config.VaultConfig) (*VaultClient, error) {
 	defaultConfig := vault.DefaultConfig()
 	if config.Addr != "" {
 		defaultConfig.Address = config.Addr
 	}
+	tls := &vault.TLSConfig{
+		CACert:        config.TLS.CACert,
+		CAPath:        config.TLS.CAPath,
+		ClientCert:    config.TLS.ClientCert,
+		ClientKey:     config.TLS.ClientKey,
+		TLSServerName: config.TLS.ServerName,
+		Insecure:      config.TLS.Insecure,
+	}
+	if err := defaultConfig.ConfigureTLS(tls); err != nil {
+		return nil, err
+	}
 	client, err := vault.NewClient(defaultConfig)
-	client.SetToken("")
-	client.SetClientTimeout(2 * time.Second)
 	if err != nil {
 		return nil, err
 	}
+	client.SetToken("")
+	client.SetClientTimeout(2 * time.Second)
+	if config.Namespace != "" {
+		client.SetNamespace(config.Namespace)
+	}
 	c := &VaultClient{config: config, Client: client}
 	return c, nil
 }
 
+// loginOidc runs vault login with the address, namespace and TLS of this
+// vault in the environment of the command only, so they don't leak to other
+// hops of the pivot chain.
 func (c *VaultClient) loginOidc() error {
-	os.Setenv("VAULT_ADDR", c.config.Addr)
-	os.Setenv("VAULT_CLIENT_TIMEOUT", "2") //We set the timeout to 2, make this configurable
 	cmd := exec.Command("vault", "login", "-method", "oidc", "-token-only")
+	cmd.Env = vaultLoginEnv(os.Environ(), c.config)
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
@@ -76,8 +140,7 @@ func (c *VaultClient) loginOidc() error {
 		}
 		return err
 	}
-	token := outb.String()
-	c.SetToken(token)
+	c.SetToken(strings.TrimSpace(outb.String()))
 	secret, err := c.Auth().Token().LookupSelf()
 	if err != nil {
 		return err
@@ -87,33 +150,93 @@ func (c *VaultClient) loginOidc() error {
 	return nil
 }
 
-func (c *VaultClient) WithPivotRole(pivotConfig config.VaultConfig, profile []string) (*VaultClient, error) {
-	pivotC := &VaultClient{config: pivotConfig, Client: c.Client}
-	pivotC.LoadProfileCreds(profile)
-	_, err := pivotC.GenerateCreds() //TODO: Only if not loaded
+// vaultLoginEnv returns the environment with the vault variables set in the
+// configuration replaced by them, keeping the others. The client timeout is
+// always set to 2 seconds.
+func vaultLoginEnv(environ []string, vault config.VaultConfig) (env []string) {
+	vars := [][2]string{{vaultEnvAddrVar, vault.Addr}, {vaultEnvNamespaceVar, vault.Namespace},
+		{vaultEnvCACertVar, vault.TLS.CACert}, {vaultEnvCAPathVar, vault.TLS.CAPath},
+		{vaultEnvClientCertVar, vault.TLS.ClientCert}, {vaultEnvClientKeyVar, vault.TLS.ClientKey},
+		{vaultEnvTLSServerNameVar, vault.TLS.ServerName}, {vaultEnvClientTimeoutVar, "2"}}
+	if vault.TLS.Insecure {
+		vars = append(vars, [2]string{vaultEnvSkipVerifyVar, "true"})
+	}
+	replaced := map[string]bool{}
+	for _, v := range vars {
+		replaced[v[0]] = v[1] != ""
+	}
+	for _, line := range environ {
+		if !replaced[strings.SplitN(line, "=", 2)[0]] {
+			env = append(env, line)
+		}
+	}
+	for _, v := range vars {
+		if v[1] != "" {
+			env = append(env, fmt.Sprintf("%s=%s", v[0], v[1]))
+		}
+	}
+	return
+}
+
+// CheckPath returns the mount of the path and the capabilities of the token on
+// it. The capability to read or list KV v2 secrets is checked on their data
+// or metadata path. An error reading the mount is returned as it is, as
+// Vault answers permission denied for paths without secrets engine too.
+func (c *VaultClient) CheckPath(path VaultPath) (mount string, capabilities []string, err error) {
+	path.Path = strings.Trim(path.Path, "/")
+	aclPath := path.Path
+	if strings.HasPrefix(path.Path, "auth/") {
+		// Auth methods are not secrets engines, only the capability is checked.
+		capabilities, err = c.Sys().CapabilitiesSelf(aclPath)
+		return "auth", capabilities, apiErrors(err)
+	}
+	secret, err := c.Logical().Read(mountsInfoPath + path.Path)
 	if err != nil {
-		return nil, err
+		return "", nil, apiErrors(err)
+	}
+	if secret == nil {
+		return "", nil, fmt.Errorf("no secrets engine mounted in %s", path.Path)
+	}
+	mount = strings.Trim(fmt.Sprint(secret.Data["path"]), "/")
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+		relative := strings.TrimPrefix(strings.TrimPrefix(path.Path, mount), "/")
+		if path.Capability == "list" || path.Metadata {
+			aclPath = fmt.Sprintf("%s/metadata/%s", mount, strings.TrimPrefix(relative, "metadata/"))
+		} else {
+			aclPath = fmt.Sprintf("%s/data/%s", mount, strings.TrimPrefix(relative, "data/"))
+		}
 	}
-	pivotC.config = c.config
-	c.Pivot = pivotC
-	return pivotC, nil
+	capabilities, err = c.Sys().CapabilitiesSelf(aclPath)
+	return mount, capabilities, apiErrors(err)
+}
+
+// apiErrors returns the errors of a vault response in a line.
+func apiErrors(err error) error {
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) && len(respErr.Errors) > 0 {
+		return fmt.Errorf("status %d - %s", respErr.StatusCode, strings.Join(respErr.Errors, ", "))
+	}
+	return err
+}
+
+func (c *VaultClient) WithPivot(pivot *VaultClient) {
+	c.Pivot = pivot
 }
 
 func (c *VaultClient) loginToken() error {
 	if c.config.Config.Role != "" {
-		client := c
+		// The token role lives in this Vault, the pivot token is only used to
+		// authenticate the creation request.
 		if c.Pivot != nil {
-			client = c.Pivot
+			c.SetToken(c.Pivot.Token())
 		}
 		//TODO: Check if policies exist or not
-		tokenSecret, err := client.Auth().Token().CreateWithRole(&vault.TokenCreateRequest{Policies: c.config.Config.Policies}, c.config.Config.Role)
-		if err == nil {
-			c.SetToken(tokenSecret.Auth.ClientToken)
-			dur, _ := time.ParseDuration(fmt.Sprintf("%ds", tokenSecret.Auth.LeaseDuration))
-			c.TTL = time.Now().Add(dur)
-		} else {
+		tokenSecret, err := c.Auth().Token().CreateWithRole(&vault.TokenCreateRequest{Policies: c.config.Config.Policies}, c.config.Config.Role)
+		if err != nil {
+			c.SetToken("")
 			return err
 		}
+		c.setAuth(tokenSecret)
 	} else if c.config.Config.Token != "" {
 		c.SetToken(c.config.Config.Token)
 	}
@@ -121,29 +244,210 @@ func (c *VaultClient) loginToken() error {
 	return nil
 }
 
+// fromPivot reads a field of the secret stored in the pivot Vault under the
+// configured path.
+func (c *VaultClient) fromPivot(key string) (string, error) {
+	if c.Pivot == nil {
+		return "", fmt.Errorf("method %s needs a pivoting profile", c.config.Method)
+	}
+	if c.config.Config.SecretPath == "" {
+		return "", fmt.Errorf("method %s needs a path in the pivot vault", c.config.Method)
+	}
+	secret, err := c.Pivot.Logical().Read(c.config.Config.SecretPath)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("secret %s not found in pivot vault", c.config.Config.SecretPath)
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s", key, c.config.Config.SecretPath)
+	}
+	return value, nil
+}
+
+func (c *VaultClient) mount(method string) string {
+	if c.config.Config.Mount != "" {
+		return c.config.Config.Mount
+	}
+	return method
+}
+
+func (c *VaultClient) loginUnwrap() error {
+	wrappingToken, err := c.fromPivot("wrapping_token")
+	if err != nil {
+		return err
+	}
+	c.SetToken("")
+	secret, err := c.Logical().Unwrap(wrappingToken)
+	if err != nil {
+		c.SetToken("")
+		return err
+	}
+	if secret == nil {
+		c.SetToken("")
+		return fmt.Errorf("empty response unwrapping token")
+	}
+	if secret.Auth != nil {
+		c.setAuth(secret)
+		return nil
+	}
+	token, ok := secret.Data["token"].(string)
+	if !ok {
+		c.SetToken("")
+		return fmt.Errorf("wrapped response does not contain a token")
+	}
+	c.SetToken(token)
+	secret, err = c.Auth().Token().LookupSelf()
+	if err != nil {
+		return err
+	}
+	duration, _ := secret.TokenTTL()
+	c.TTL = time.Now().Add(duration)
+	return nil
+}
+
+func (c *VaultClient) loginAppRole() (err error) {
+	roleID := c.config.Config.RoleID
+	if roleID == "" {
+		if roleID, err = c.fromPivot("role_id"); err != nil {
+			return
+		}
+	}
+	secretID, err := c.fromPivot("secret_id")
+	if err != nil {
+		return
+	}
+	return c.login(c.mount("approle"), map[string]interface{}{"role_id": roleID, "secret_id": secretID})
+}
+
+func (c *VaultClient) loginJWT() error {
+	jwt, err := c.fromPivot("jwt")
+	if err != nil {
+		return err
+	}
+	return c.login(c.mount("jwt"), map[string]interface{}{"role": c.config.Config.Role, "jwt": jwt})
+}
+
+func (c *VaultClient) login(mount string, data map[string]interface{}) error {
+	c.SetToken("")
+	secret, err := c.Logical().Write(fmt.Sprintf("auth/%s/login", mount), data)
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Auth == nil {
+		return fmt.Errorf("no auth information returned by auth/%s/login", mount)
+	}
+	c.setAuth(secret)
+	return nil
+}
+
+func (c *VaultClient) setAuth(secret *vault.Secret) {
+	c.SetToken(secret.Auth.ClientToken)
+	dur, _ := time.ParseDuration(fmt.Sprintf("%ds", secret.Auth.LeaseDuration))
+	c.TTL = time.Now().Add(dur)
+}
+
+// scopedToken creates a short lived token with a few uses, to be handed to
+// external programs. It only has the policies given or the ones of the token
+// role, and must be revoked with revokeScopedToken once they finish.
+func (c *VaultClient) scopedToken(name string, role string, policies []string) (string, error) {
+	request := &vault.TokenCreateRequest{TTL: scopedTokenTTL, DisplayName: name, Policies: policies, NumUses: scopedTokenUses}
+	var secret *vault.Secret
+	var err error
+	switch {
+	case role != "":
+		secret, err = c.Auth().Token().CreateWithRole(request, role)
+	case len(policies) > 0:
+		secret, err = c.Auth().Token().Create(request)
+	default:
+		return "", fmt.Errorf("a token role or policies are required for the scoped token")
+	}
+	if err != nil {
+		return "", apiErrors(err)
+	}
+	return secret.Auth.ClientToken, nil
+}
+
+// revokeScopedToken revokes a scoped token with the token itself, which the
+// default policy allows. A token without uses left is already revoked.
+func (c *VaultClient) revokeScopedToken(token string) error {
+	client, err := c.Client.Clone()
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+	if c.config.Namespace != "" {
+		client.SetNamespace(c.config.Namespace)
+	}
+	err = client.Auth().Token().RevokeSelf("")
+	var responseErr *vault.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusForbidden {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error revoking scoped vault token - %s", apiErrors(err))
+	}
+	return nil
+}
+
+// scopedTokenPaths returns the path used to create a scoped token.
+func scopedTokenPaths(role string) []VaultPath {
+	if role != "" {
+		return []VaultPath{{Path: "auth/token/create/" + role, Capability: "update"}}
+	}
+	return []VaultPath{{Path: "auth/token/create", Capability: "update"}}
+}
+
 func (c *VaultClient) GenerateCreds() (string, error) {
 	var err error
 	//TODO: Check cause this can create token all day
-	if c.config.Method == "oidc" && c.Token() == "" {
-		err = c.loginOidc()
-	} else if c.config.Method == "token" {
+	switch c.config.Method {
+	case "oidc":
+		if c.Token() == "" {
+			err = c.loginOidc()
+		}
+	case "token":
 		err = c.loginToken()
+	case "unwrap":
+		err = c.loginUnwrap()
+	case "approle":
+		err = c.loginAppRole()
+	case "jwt":
+		err = c.loginJWT()
 	}
 	return c.Token(), err
 }
 
-func (c *VaultClient) ExportCreds() []string {
-	return []string{fmt.Sprintf("export %s=\"%s\"", vaultEnvTokenVar, c.Token()),
-		fmt.Sprintf("export %s=\"%s\"", vaultEnvTTLVar, c.TTL.Format(layout)),
-		fmt.Sprintf("export %s=\"%s\"", vaultEnvAddrVar, c.config.Addr)}
+func (c *VaultClient) ExportCreds() (export []string) {
+	for _, line := range c.ProfileCreds() {
+		export = append(export, fmt.Sprintf("export %s", line))
+	}
+	return
 }
 
 func (c *VaultClient) CredsLoaded() bool {
 	return c.Token() != ""
 }
 
+func (c *VaultClient) CacheState() CacheState {
+	return CacheState{Stored: c.stored, Expires: c.TTL}
+}
+
 func (c *VaultClient) ProfileCreds() []string {
-	return []string{fmt.Sprintf("%s=\"%s\"", vaultEnvTokenVar, c.Token()),
+	creds := []string{fmt.Sprintf("%s=\"%s\"", vaultEnvTokenVar, c.Token()),
 		fmt.Sprintf("%s=\"%s\"", vaultEnvTTLVar, c.TTL.Format(layout)),
 		fmt.Sprintf("%s=\"%s\"", vaultEnvAddrVar, c.config.Addr)}
+	if c.config.Namespace != "" {
+		creds = append(creds, fmt.Sprintf("%s=\"%s\"", vaultEnvNamespaceVar, c.config.Namespace))
+	}
+	if c.config.TLS.CACert != "" {
+		creds = append(creds, fmt.Sprintf("%s=\"%s\"", vaultEnvCACertVar, c.config.TLS.CACert))
+	}
+	return creds
 }
@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/smorenodp/clusterprofile/config"
+)
+
+func TestVaultLoginEnv(t *testing.T) {
+	environ := []string{"HOME=/home/user", "VAULT_ADDR=https://other:8200", "VAULT_NAMESPACE=team", "VAULT_CACERT=/other.pem", "VAULT_CLIENT_TIMEOUT=60"}
+	env := vaultLoginEnv(environ, config.VaultConfig{Addr: "https://vault:8200", TLS: config.VaultTLSConfig{CACert: "/ca.pem"}})
+	// The namespace is kept as it's not configured.
+	expected := "HOME=/home/user,VAULT_NAMESPACE=team,VAULT_ADDR=https://vault:8200,VAULT_CACERT=/ca.pem,VAULT_CLIENT_TIMEOUT=2"
+	if strings.Join(env, ",") != expected {
+		t.Fatalf("expected %s, got %s", expected, strings.Join(env, ","))
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	checked := []string{}
+	client := testVault(t, map[string]interface{}{
+		"/v1/sys/internal/ui/mounts/secret/app": map[string]interface{}{
+			"data": map[string]interface{}{"path": "secret/", "type": "kv", "options": map[string]interface{}{"version": "2"}},
+		},
+		"/v1/sys/internal/ui/mounts/missing/app": http.StatusForbidden,
+		"/v1/sys/capabilities-self": func(r *http.Request) interface{} {
+			var request struct{ Path string }
+			json.NewDecoder(r.Body).Decode(&request)
+			checked = append(checked, request.Path)
+			return map[string]interface{}{"data": map[string]interface{}{request.Path: []string{"read"}}}
+		},
+	})
+	for _, path := range []VaultPath{{Path: "secret/app", Capability: "read"}, {Path: "secret/app", Capability: "read", Metadata: true}} {
+		mount, capabilities, err := client.CheckPath(path)
+		if err != nil || mount != "secret" || strings.Join(capabilities, ",") != "read" {
+			t.Fatalf("unexpected check of %s: mount %s, capabilities %v - %v", path, mount, capabilities, err)
+		}
+	}
+	if strings.Join(checked, ",") != "secret/data/app,secret/metadata/app" {
+		t.Fatalf("expected the data and metadata paths to be checked, got %v", checked)
+	}
+
+	_, _, err := client.CheckPath(VaultPath{Path: "missing/app", Capability: "read"})
+	if err == nil || err.Error() != "status 403 - test error" {
+		t.Fatalf("expected the error of vault, got %v", err)
+	}
+}